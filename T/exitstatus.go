@@ -6,6 +6,7 @@ type ExitStatus int
 const (
 	ExitSuccess        ExitStatus = 0
 	ExitFailure        ExitStatus = 1
+	ExitUsage          ExitStatus = 2
 	ExitNotExecutable  ExitStatus = 126
 	ExitUnknownCommand ExitStatus = 127
 )
@@ -1,9 +1,91 @@
 package T
 
-import "io"
+import (
+	"errors"
+	"io"
+)
+
+// ErrBadFd is returned when reading or writing a descriptor that has been
+// closed or was never opened.
+var ErrBadFd = errors.New("Bad file descriptor")
 
 type IOContainer struct {
 	In  io.Reader
 	Out io.Writer
 	Err io.Writer
+	// Extra holds descriptors above 2 opened by redirections, E.g '3>file'.
+	Extra map[int]interface{}
+}
+
+// ClosedFd stands in for a descriptor closed with 'n<&-' or 'n>&-'.
+type ClosedFd struct{}
+
+func (ClosedFd) Read([]byte) (int, error)  { return 0, ErrBadFd }
+func (ClosedFd) Write([]byte) (int, error) { return 0, ErrBadFd }
+
+// Copy returns a shallow copy of the container so redirections can be
+// applied without affecting the caller.
+func (ioc *IOContainer) Copy() *IOContainer {
+	newIOC := &IOContainer{In: ioc.In, Out: ioc.Out, Err: ioc.Err}
+	if len(ioc.Extra) > 0 {
+		newIOC.Extra = map[int]interface{}{}
+		for k, v := range ioc.Extra {
+			newIOC.Extra[k] = v
+		}
+	}
+	return newIOC
+}
+
+// Fd returns the stream open on descriptor n. The second value is false if
+// the descriptor is not open.
+func (ioc *IOContainer) Fd(n int) (interface{}, bool) {
+	var f interface{}
+	switch n {
+	case 0:
+		f = ioc.In
+	case 1:
+		f = ioc.Out
+	case 2:
+		f = ioc.Err
+	default:
+		f = ioc.Extra[n]
+	}
+	if _, closed := f.(ClosedFd); closed || f == nil {
+		return nil, false
+	}
+	return f, true
+}
+
+// SetFd opens f on descriptor n. A nil f closes the descriptor.
+func (ioc *IOContainer) SetFd(n int, f interface{}) {
+	if f == nil {
+		f = ClosedFd{}
+	}
+	switch n {
+	case 0:
+		if r, ok := f.(io.Reader); ok {
+			ioc.In = r
+		} else {
+			ioc.In = ClosedFd{}
+		}
+	case 1, 2:
+		w, ok := f.(io.Writer)
+		if !ok {
+			w = ClosedFd{}
+		}
+		if n == 1 {
+			ioc.Out = w
+		} else {
+			ioc.Err = w
+		}
+	default:
+		if ioc.Extra == nil {
+			ioc.Extra = map[int]interface{}{}
+		}
+		if _, closed := f.(ClosedFd); closed {
+			delete(ioc.Extra, n)
+			return
+		}
+		ioc.Extra[n] = f
+	}
 }
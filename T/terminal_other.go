@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package T
+
+import "os"
+
+// IsTerminal reports whether f refers to a terminal. Without ioctl(2)
+// any character device is assumed to be one.
+func IsTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
@@ -2,11 +2,10 @@ package arith
 
 import (
 	"errors"
+	"fmt"
 	"runtime"
 	"strconv"
 
-	"gopkg.in/logex.v1"
-
 	"github.com/danwakefield/gosh/variables"
 )
 
@@ -49,7 +48,6 @@ func Parse(input string, scp *variables.Scope) (i int64, err error) {
 			case error:
 				err = ParseError{Err: t}
 			}
-			logex.Fatal(err)
 		}
 	}()
 	p := &Parser{
@@ -153,7 +151,9 @@ func (p *Parser) getVariable(name string) int64 {
 
 func (p *Parser) setVariable(name string, val int64) {
 	if !p.blockAssignments {
-		p.scope.Set(name, strconv.FormatInt(val, 10))
+		if err := p.scope.Set(name, strconv.FormatInt(val, 10)); err != nil {
+			panic(fmt.Errorf("%s: %w", name, err))
+		}
 	}
 }
 
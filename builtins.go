@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/danwakefield/gosh/T"
+	"github.com/danwakefield/gosh/builtins"
+	"github.com/danwakefield/gosh/variables"
+)
+
+// The builtins that need to parse shell code live here as the builtins
+// package cannot import the parser.
+func init() {
+	builtins.All["."] = SourceCmd
+	builtins.All["source"] = SourceCmd
+	builtins.All["eval"] = EvalCmd
+}
+
+// EvalCmd joins its arguments with spaces and evaluates the result as
+// commands in the current shell. A syntax error gives a non-zero status.
+//
+//	eval [args...]
+func EvalCmd(scp *variables.Scope, ioc *T.IOContainer, args []string) T.ExitStatus {
+	if len(args) == 0 {
+		return T.ExitSuccess
+	}
+	return evalString(scp, ioc, strings.Join(args, " "))
+}
+
+// SourceCmd reads and evaluates file in the current shell. When args are
+// given they replace the positional parameters while it runs. return can
+// be used to stop evaluating the file.
+//
+//	. file [args...]
+func SourceCmd(scp *variables.Scope, ioc *T.IOContainer, args []string) T.ExitStatus {
+	if len(args) == 0 {
+		fmt.Fprintf(ioc.Err, ".: filename argument required\n")
+		return T.ExitUsage
+	}
+
+	path, found := findSourceFile(scp, args[0])
+	if !found {
+		fmt.Fprintf(ioc.Err, ".: cannot open %s: No such file\n", args[0])
+		ExitShell(scp, T.ExitUsage)
+		return T.ExitUsage
+	}
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		fmt.Fprintf(ioc.Err, ".: cannot open %s: %s\n", args[0], err.Error())
+		ExitShell(scp, T.ExitUsage)
+		return T.ExitUsage
+	}
+
+	if len(args) > 1 {
+		scp.PushFunction(args[1:])
+		defer scp.Pop()
+	}
+	scp.EnterSource()
+	defer scp.LeaveSource()
+
+	ex := evalString(scp, ioc, string(src))
+	if c, _ := scp.Control(); c == variables.ControlReturn {
+		scp.ClearControl()
+	}
+	return ex
+}
+
+// findSourceFile returns the path of the file to be sourced. A name
+// without a slash is searched for in $PATH.
+func findSourceFile(scp *variables.Scope, name string) (string, bool) {
+	if strings.ContainsRune(name, '/') {
+		path := scp.AbsPath(name)
+		return path, isRegularFile(path)
+	}
+
+	pathVar := scp.Get("PATH")
+	dirs := pathVar.Val
+	if !pathVar.Set {
+		dirs = os.Getenv("PATH")
+	}
+	for _, dir := range filepath.SplitList(dirs) {
+		// An empty entry is the current directory
+		path := scp.AbsPath(filepath.Join(dir, name))
+		if isRegularFile(path) {
+			return path, true
+		}
+	}
+	return "", false
+}
+
+func isRegularFile(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && fi.Mode().IsRegular()
+}
@@ -9,9 +9,55 @@ import (
 type Builtin func(scp *variables.Scope, ioc *T.IOContainer, args []string) T.ExitStatus
 
 var All = map[string]Builtin{
-	"true":  TrueCmd,
-	":":     TrueCmd,
-	"false": FalseCmd,
-	"cd":    CdCmd,
-	"local": LocalCmd,
+	"true":    TrueCmd,
+	":":       TrueCmd,
+	"false":   FalseCmd,
+	"test":    TestCmd,
+	"[":       BracketCmd,
+	"echo":    EchoCmd,
+	"printf":  PrintfCmd,
+	"read":    ReadCmd,
+	"cd":      CdCmd,
+	"pwd":     PwdCmd,
+	"umask":   UmaskCmd,
+	"ulimit":  UlimitCmd,
+	"times":   TimesCmd,
+	"local":   LocalCmd,
+	"set":     SetCmd,
+	"shift":   ShiftCmd,
+	"getopts": GetoptsCmd,
+	"wait":    WaitCmd,
+	"jobs":    JobsCmd,
+	"fg":      FgCmd,
+	"bg":      BgCmd,
+	"trap":    TrapCmd,
+
+	"export":   ExportCmd,
+	"readonly": ReadonlyCmd,
+	"unset":    UnsetCmd,
+
+	"break":    BreakCmd,
+	"continue": ContinueCmd,
+	"return":   ReturnCmd,
+	"exit":     ExitCmd,
+}
+
+// Special lists the special builtins. Assignments before a special
+// builtin remain in the shell after it has run, for other builtins they
+// only apply while it runs.
+var Special = map[string]bool{
+	".":        true,
+	":":        true,
+	"break":    true,
+	"continue": true,
+	"eval":     true,
+	"exit":     true,
+	"export":   true,
+	"readonly": true,
+	"return":   true,
+	"set":      true,
+	"shift":    true,
+	"times":    true,
+	"trap":     true,
+	"unset":    true,
 }
@@ -2,7 +2,6 @@ package builtins
 
 import (
 	"fmt"
-	"os"
 	"os/user"
 
 	"github.com/danwakefield/gosh/T"
@@ -31,7 +30,7 @@ func CdCmd(scp *variables.Scope, ioc *T.IOContainer, args []string) T.ExitStatus
 	}
 
 	if err := scp.SetPwd(cdTarget); err != nil {
-		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		fmt.Fprintf(ioc.Err, "cd: %s\n", err.Error())
 		return T.ExitFailure
 	}
 	return T.ExitSuccess
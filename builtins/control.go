@@ -0,0 +1,91 @@
+package builtins
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/danwakefield/gosh/T"
+	"github.com/danwakefield/gosh/variables"
+)
+
+// BreakCmd exits from the n enclosing loops, 1 by default.
+//
+//	break [n]
+func BreakCmd(scp *variables.Scope, ioc *T.IOContainer, args []string) T.ExitStatus {
+	return loopControl("break", variables.ControlBreak, scp, ioc, args)
+}
+
+// ContinueCmd starts the next iteration of the nth enclosing loop, 1 by
+// default.
+//
+//	continue [n]
+func ContinueCmd(scp *variables.Scope, ioc *T.IOContainer, args []string) T.ExitStatus {
+	return loopControl("continue", variables.ControlContinue, scp, ioc, args)
+}
+
+func loopControl(name string, c variables.Control, scp *variables.Scope, ioc *T.IOContainer, args []string) T.ExitStatus {
+	n := 1
+	if len(args) > 0 {
+		var err error
+		n, err = strconv.Atoi(args[0])
+		if err != nil || n < 1 {
+			fmt.Fprintf(ioc.Err, "%s: Illegal number: %s\n", name, args[0])
+			return T.ExitUsage
+		}
+	}
+
+	// Outside of a loop there is nothing to do. A count larger than the
+	// number of loops affects the outermost.
+	depth := scp.LoopDepth()
+	if depth == 0 {
+		return T.ExitSuccess
+	}
+	if n > depth {
+		n = depth
+	}
+	scp.SetControl(c, n)
+	return T.ExitSuccess
+}
+
+// ReturnCmd returns from a function or sourced file with status n, or the
+// status of the last command if n is not given.
+//
+//	return [n]
+func ReturnCmd(scp *variables.Scope, ioc *T.IOContainer, args []string) T.ExitStatus {
+	ex := scp.ExitStatus()
+	if len(args) > 0 {
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 0 {
+			fmt.Fprintf(ioc.Err, "return: Illegal number: %s\n", args[0])
+			return T.ExitUsage
+		}
+		ex = T.ExitStatus(n & 0xff)
+	}
+
+	if !scp.InFunction() {
+		fmt.Fprintf(ioc.Err, "return: Not in a function\n")
+		return T.ExitFailure
+	}
+	scp.SetControl(variables.ControlReturn, 0)
+	return ex
+}
+
+// ExitCmd exits the shell, or the enclosing subshell, with status n or the
+// status of the last command if n is not given. The EXIT trap runs first.
+//
+//	exit [n]
+func ExitCmd(scp *variables.Scope, ioc *T.IOContainer, args []string) T.ExitStatus {
+	ex := scp.ExitStatus()
+	if len(args) > 0 {
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 0 {
+			fmt.Fprintf(ioc.Err, "exit: Illegal number: %s\n", args[0])
+			ex = T.ExitUsage
+		} else {
+			ex = T.ExitStatus(n & 0xff)
+		}
+	}
+
+	scp.SetControl(variables.ControlExit, int(ex))
+	return ex
+}
@@ -0,0 +1,63 @@
+package builtins
+
+import (
+	"io"
+	"strings"
+
+	"github.com/danwakefield/gosh/T"
+	"github.com/danwakefield/gosh/variables"
+)
+
+// EchoCmd writes its arguments separated by spaces and followed by a
+// newline. It behaves like the echo of GNU coreutils so scripts give the
+// same output as when it was run as an external command.
+//
+//	echo [-neE] [args...]
+//
+// -n omits the trailing newline. -e interprets backslash escapes in the
+// arguments, -E turns that off again and is the default. The escapes are
+// \\ \a \b \c \e \f \n \r \t \v, \0NNN for an octal value and \xHH for a
+// hexadecimal one. \c ends the output without a newline.
+// An argument is only an option if it is made up of those letters, any
+// other argument and those following it are written as they are.
+func EchoCmd(scp *variables.Scope, ioc *T.IOContainer, args []string) T.ExitStatus {
+	newline := true
+	escapes := false
+	for len(args) > 0 && isEchoOption(args[0]) {
+		for _, c := range args[0][1:] {
+			switch c {
+			case 'n':
+				newline = false
+			case 'e':
+				escapes = true
+			case 'E':
+				escapes = false
+			}
+		}
+		args = args[1:]
+	}
+
+	out := strings.Join(args, " ")
+	if escapes {
+		var stop bool
+		out, stop = unescapeAll(out)
+		if stop {
+			newline = false
+		}
+	}
+	if newline {
+		out += "\n"
+	}
+
+	if _, err := io.WriteString(ioc.Out, out); err != nil {
+		return T.ExitFailure
+	}
+	return T.ExitSuccess
+}
+
+func isEchoOption(arg string) bool {
+	if len(arg) < 2 || arg[0] != '-' {
+		return false
+	}
+	return strings.Trim(arg[1:], "neE") == ""
+}
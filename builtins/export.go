@@ -0,0 +1,80 @@
+package builtins
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/danwakefield/gosh/T"
+	"github.com/danwakefield/gosh/char"
+	"github.com/danwakefield/gosh/variables"
+)
+
+// ExportCmd marks variables to be passed in the environment of commands,
+// assigning them first when given as name=value. With -p, or without
+// names, the exported variables are printed as commands to recreate them.
+//
+//	export [-p] [name[=value]...]
+func ExportCmd(scp *variables.Scope, ioc *T.IOContainer, args []string) T.ExitStatus {
+	isExported := func(v variables.Variable) bool { return v.Exported }
+	return attributeCmd("export", scp.Export, isExported, scp, ioc, args)
+}
+
+// ReadonlyCmd prevents variables being assigned or unset, assigning them
+// first when given as name=value. With -p, or without names, the readonly
+// variables are printed as commands to recreate them.
+//
+//	readonly [-p] [name[=value]...]
+func ReadonlyCmd(scp *variables.Scope, ioc *T.IOContainer, args []string) T.ExitStatus {
+	isReadOnly := func(v variables.Variable) bool { return v.ReadOnly }
+	return attributeCmd("readonly", scp.SetReadOnly, isReadOnly, scp, ioc, args)
+}
+
+func attributeCmd(name string, mark func(string), isMarked func(variables.Variable) bool, scp *variables.Scope, ioc *T.IOContainer, args []string) T.ExitStatus {
+	if len(args) > 0 && (args[0] == "-p" || args[0] == "--") {
+		args = args[1:]
+	}
+	if len(args) > 0 && len(args[0]) > 1 && args[0][0] == '-' {
+		fmt.Fprintf(ioc.Err, "%s: Illegal option %s\n", name, args[0])
+		return T.ExitUsage
+	}
+
+	if len(args) == 0 {
+		vars := scp.Variables()
+		names := []string{}
+		for k, v := range vars {
+			if isMarked(v) {
+				names = append(names, k)
+			}
+		}
+		sort.Strings(names)
+
+		for _, k := range names {
+			if v := vars[k]; v.Set {
+				fmt.Fprintf(ioc.Out, "%s %s=%s\n", name, k, char.Quote(v.Val))
+			} else {
+				fmt.Fprintf(ioc.Out, "%s %s\n", name, k)
+			}
+		}
+		return T.ExitSuccess
+	}
+
+	ex := T.ExitSuccess
+	for _, a := range args {
+		parts := strings.SplitN(a, "=", 2)
+		if !variables.IsGoodName(parts[0]) {
+			fmt.Fprintf(ioc.Err, "%s: %s: bad variable name\n", name, parts[0])
+			ex = T.ExitFailure
+			continue
+		}
+		if len(parts) == 2 {
+			if err := scp.Set(parts[0], parts[1]); err != nil {
+				fmt.Fprintf(ioc.Err, "%s: %s: %s\n", name, parts[0], err.Error())
+				ex = T.ExitFailure
+				continue
+			}
+		}
+		mark(parts[0])
+	}
+	return ex
+}
@@ -0,0 +1,130 @@
+package builtins
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/danwakefield/gosh/T"
+	"github.com/danwakefield/gosh/variables"
+)
+
+// GetoptsCmd parses the options in args, or the positional parameters,
+// one at a time. Each call sets name to the next option letter and OPTARG
+// to its argument if optstring has a ':' after the letter. OPTIND is the
+// index of the next argument to be parsed. When the options are finished
+// name is set to '?' and the status is non zero.
+//
+// Errors are written to ioc.Err and name is set to '?'. If optstring
+// starts with ':' errors are silent, an unknown option sets name to '?'
+// and a missing argument to ':' with OPTARG set to the option letter.
+//
+//	getopts optstring name [args...]
+func GetoptsCmd(scp *variables.Scope, ioc *T.IOContainer, args []string) T.ExitStatus {
+	if len(args) < 2 {
+		fmt.Fprintf(ioc.Err, "getopts: usage: getopts optstring name [arg...]\n")
+		return T.ExitUsage
+	}
+	optstring, name := args[0], args[1]
+	if !variables.IsGoodName(name) {
+		fmt.Fprintf(ioc.Err, "getopts: %s: bad variable name\n", name)
+		return T.ExitUsage
+	}
+	params := args[2:]
+	if len(args) == 2 {
+		params = scp.PositionalArgs()
+	}
+	silent := strings.HasPrefix(optstring, ":")
+	if silent {
+		optstring = optstring[1:]
+	}
+
+	optind, err := strconv.Atoi(scp.Get("OPTIND").Val)
+	if err != nil || optind < 1 {
+		optind = 1
+	}
+	state := &scp.Getopts
+	// The arguments may have changed since the state was saved
+	if state.Index != optind || optind > len(params) || state.Char >= len(params[optind-1]) {
+		state.Char = 0
+	}
+
+	set := func(opt, optarg string, unsetOptarg bool) error {
+		// Setting OPTIND clears the state so it is restored afterwards
+		saved := *state
+		err := scp.Set("OPTIND", strconv.Itoa(optind))
+		*state = saved
+		state.Index = optind
+		if err != nil {
+			return err
+		}
+		if unsetOptarg {
+			scp.Unset("OPTARG")
+		} else if err := scp.Set("OPTARG", optarg); err != nil {
+			return err
+		}
+		return scp.Set(name, opt)
+	}
+	finish := func(opt, optarg string, unsetOptarg bool, ex T.ExitStatus) T.ExitStatus {
+		if err := set(opt, optarg, unsetOptarg); err != nil {
+			fmt.Fprintf(ioc.Err, "getopts: %s\n", err.Error())
+			return T.ExitFailure
+		}
+		return ex
+	}
+
+	if state.Char == 0 {
+		if optind > len(params) {
+			return finish("?", "", true, T.ExitFailure)
+		}
+		arg := params[optind-1]
+		if arg == "--" {
+			optind++
+			return finish("?", "", true, T.ExitFailure)
+		}
+		if len(arg) < 2 || arg[0] != '-' {
+			return finish("?", "", true, T.ExitFailure)
+		}
+		state.Char = 1
+	}
+
+	arg := params[optind-1]
+	c := arg[state.Char]
+	state.Char++
+	if state.Char >= len(arg) {
+		optind++
+		state.Char = 0
+	}
+
+	i := strings.IndexByte(optstring, c)
+	if i == -1 || c == ':' {
+		if silent {
+			return finish("?", string(c), false, T.ExitSuccess)
+		}
+		fmt.Fprintf(ioc.Err, "getopts: Illegal option -%c\n", c)
+		return finish("?", "", true, T.ExitSuccess)
+	}
+
+	if i+1 >= len(optstring) || optstring[i+1] != ':' {
+		return finish(string(c), "", true, T.ExitSuccess)
+	}
+
+	// The argument is either the rest of this one, E.g '-ofile', or the
+	// next argument.
+	switch {
+	case state.Char != 0:
+		optarg := arg[state.Char:]
+		optind++
+		state.Char = 0
+		return finish(string(c), optarg, false, T.ExitSuccess)
+	case optind <= len(params):
+		optarg := params[optind-1]
+		optind++
+		return finish(string(c), optarg, false, T.ExitSuccess)
+	case silent:
+		return finish(":", string(c), false, T.ExitSuccess)
+	default:
+		fmt.Fprintf(ioc.Err, "getopts: No arg for -%c option\n", c)
+		return finish("?", "", true, T.ExitSuccess)
+	}
+}
@@ -0,0 +1,148 @@
+package builtins
+
+import (
+	"fmt"
+	"syscall"
+
+	"github.com/danwakefield/gosh/T"
+	"github.com/danwakefield/gosh/jobs"
+	"github.com/danwakefield/gosh/variables"
+)
+
+// JobsCmd lists the jobs of the shell.
+//
+//	jobs [-l|-p] [job...]
+//
+// -l includes the pid of each job and -p prints only the process group
+// leaders. Jobs reported as finished are removed from the table.
+func JobsCmd(scp *variables.Scope, ioc *T.IOContainer, args []string) T.ExitStatus {
+	long, pidsOnly := false, false
+	for len(args) > 0 && len(args[0]) > 1 && args[0][0] == '-' {
+		if args[0] == "--" {
+			args = args[1:]
+			break
+		}
+		for _, c := range args[0][1:] {
+			switch c {
+			case 'l':
+				long = true
+			case 'p':
+				pidsOnly = true
+			default:
+				fmt.Fprintf(ioc.Err, "jobs: Illegal option -%c\n", c)
+				return T.ExitUsage
+			}
+		}
+		args = args[1:]
+	}
+
+	list := scp.Jobs.All()
+	if len(args) > 0 {
+		list = nil
+		for _, spec := range args {
+			j, err := scp.Jobs.Find(spec)
+			if err != nil {
+				fmt.Fprintf(ioc.Err, "jobs: %s: %s\n", spec, err.Error())
+				return T.ExitFailure
+			}
+			list = append(list, j)
+		}
+	}
+
+	// Every line is formatted before finished jobs are removed so the
+	// current and previous markers are consistent.
+	lines := []string{}
+	for _, j := range list {
+		if pidsOnly {
+			pid := j.Pgid()
+			if pid == 0 {
+				pid = j.Pid()
+			}
+			lines = append(lines, fmt.Sprint(pid))
+		} else {
+			lines = append(lines, scp.Jobs.Format(j, long))
+		}
+	}
+	for _, l := range lines {
+		fmt.Fprintln(ioc.Out, l)
+	}
+	for _, j := range list {
+		if j.Done() {
+			scp.Jobs.Remove(j)
+		}
+	}
+	return T.ExitSuccess
+}
+
+// FgCmd continues a job in the foreground and waits for it to finish or
+// be stopped again.
+//
+//	fg [job]
+func FgCmd(scp *variables.Scope, ioc *T.IOContainer, args []string) T.ExitStatus {
+	j, ex := findControlledJob("fg", scp, ioc, args)
+	if j == nil {
+		return ex
+	}
+
+	fmt.Fprintln(ioc.Out, j.Command)
+	jobs.SetForeground(j.Pgid())
+	if err := j.Continue(); err != nil {
+		jobs.RestoreForeground()
+		fmt.Fprintf(ioc.Err, "fg: %s\n", err.Error())
+		return T.ExitFailure
+	}
+	stopped := j.WaitForeground()
+	jobs.RestoreForeground()
+
+	if stopped {
+		fmt.Fprintf(ioc.Err, "\n%s\n", scp.Jobs.Format(j, false))
+		return T.ExitStatus(128 + int(syscall.SIGTSTP))
+	}
+	scp.Jobs.Remove(j)
+	return j.Wait()
+}
+
+// BgCmd continues stopped jobs in the background.
+//
+//	bg [job...]
+func BgCmd(scp *variables.Scope, ioc *T.IOContainer, args []string) T.ExitStatus {
+	if len(args) == 0 {
+		args = []string{"%+"}
+	}
+
+	returnExit := T.ExitSuccess
+	for _, spec := range args {
+		j, ex := findControlledJob("bg", scp, ioc, []string{spec})
+		if j == nil {
+			returnExit = ex
+			continue
+		}
+		if err := j.Continue(); err != nil {
+			fmt.Fprintf(ioc.Err, "bg: %s\n", err.Error())
+			returnExit = T.ExitFailure
+			continue
+		}
+		fmt.Fprintf(ioc.Out, "[%d] %s &\n", j.ID, j.Command)
+	}
+	return returnExit
+}
+
+// findControlledJob returns the job given by the first argument, or the
+// current job, for fg and bg. These require job control to be enabled.
+func findControlledJob(name string, scp *variables.Scope, ioc *T.IOContainer, args []string) (*jobs.Job, T.ExitStatus) {
+	if !scp.Option(variables.OptionMonitor) {
+		fmt.Fprintf(ioc.Err, "%s: No job control\n", name)
+		return nil, T.ExitFailure
+	}
+
+	spec := "%+"
+	if len(args) > 0 {
+		spec = args[0]
+	}
+	j, err := scp.Jobs.Find(spec)
+	if err != nil {
+		fmt.Fprintf(ioc.Err, "%s: %s: %s\n", name, spec, err.Error())
+		return nil, T.ExitFailure
+	}
+	return j, T.ExitSuccess
+}
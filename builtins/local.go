@@ -1,6 +1,8 @@
 package builtins
 
 import (
+	"fmt"
+
 	"github.com/danwakefield/gosh/T"
 	"github.com/danwakefield/gosh/variables"
 )
@@ -8,13 +10,19 @@ import (
 func LocalCmd(scp *variables.Scope, ioc *T.IOContainer, args []string) T.ExitStatus {
 	// Local should also do assignments, split args on equal sign? already
 	// expanded
+	ex := T.ExitSuccess
 	for _, a := range args {
 		tmp := scp.Get(a)
+		var err error
 		if tmp.Set {
-			scp.Set(a, tmp.Val, variables.LocalScope)
+			err = scp.Set(a, tmp.Val, variables.LocalScope)
 		} else {
-			scp.Set(a, "", variables.LocalScope)
+			err = scp.Set(a, "", variables.LocalScope)
+		}
+		if err != nil {
+			fmt.Fprintf(ioc.Err, "local: %s: %s\n", a, err.Error())
+			ex = T.ExitFailure
 		}
 	}
-	return T.ExitSuccess
+	return ex
 }
@@ -0,0 +1,356 @@
+package builtins
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/danwakefield/gosh/T"
+	"github.com/danwakefield/gosh/variables"
+)
+
+// PrintfCmd writes args according to format. The format is reused until
+// all of the arguments have been consumed. Missing arguments are treated
+// as an empty string or zero.
+//
+//	printf format [args...]
+//
+// The conversions are %s %b %c %d %i %o %u %x %X %e %E %f %F %g %G and %%
+// with the flags '-+ #0', a field width and a precision, either of which
+// can be '*' to take it from the arguments. %b writes its argument with
+// the escapes understood by 'echo -e'. Numeric arguments can be written
+// in octal or hexadecimal as in C, or as a quote followed by a character
+// for its value. E.g "'A" is 65
+func PrintfCmd(scp *variables.Scope, ioc *T.IOContainer, args []string) T.ExitStatus {
+	if len(args) > 0 && args[0] == "--" {
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		fmt.Fprintf(ioc.Err, "printf: usage: printf format [arg ...]\n")
+		return T.ExitUsage
+	}
+
+	p := &printfState{format: args[0], args: args[1:], ioc: ioc}
+	for {
+		used := p.argIndex
+		if stop := p.formatOnce(); stop {
+			break
+		}
+		// Reuse the format while there are arguments left, as long as
+		// it consumes some of them.
+		if p.argIndex >= len(p.args) || p.argIndex == used {
+			break
+		}
+	}
+
+	if _, err := ioc.Out.Write(p.out.Bytes()); err != nil {
+		return T.ExitFailure
+	}
+	return p.ex
+}
+
+type printfState struct {
+	format   string
+	args     []string
+	argIndex int
+	out      bytes.Buffer
+	ioc      *T.IOContainer
+	ex       T.ExitStatus
+}
+
+func (p *printfState) nextArg() (string, bool) {
+	if p.argIndex >= len(p.args) {
+		return "", false
+	}
+	p.argIndex++
+	return p.args[p.argIndex-1], true
+}
+
+// formatOnce writes the format once. stop is true if output should end,
+// either because of '\c' in a %b argument or an invalid conversion.
+func (p *printfState) formatOnce() (stop bool) {
+	f := p.format
+	for i := 0; i < len(f); i++ {
+		switch f[i] {
+		case '\\':
+			s, n := unescape(f[i+1:], false)
+			p.out.WriteString(s)
+			i += n
+		case '%':
+			n, stop := p.conversion(f[i+1:])
+			if stop {
+				return true
+			}
+			i += n
+		default:
+			p.out.WriteByte(f[i])
+		}
+	}
+	return false
+}
+
+// conversion writes a single conversion specification, f is the format
+// following the '%'. It returns the number of bytes of f used.
+func (p *printfState) conversion(f string) (int, bool) {
+	i := 0
+	flags := ""
+	for i < len(f) && strings.IndexByte("-+ #0", f[i]) != -1 {
+		flags += string(f[i])
+		i++
+	}
+	width, n := p.specNumber(f[i:])
+	i += n
+	precision := ""
+	if i < len(f) && f[i] == '.' {
+		i++
+		prec, n := p.specNumber(f[i:])
+		i += n
+		precision = "." + prec
+		if prec == "" {
+			precision = ".0"
+		}
+	}
+	if i >= len(f) {
+		fmt.Fprintf(p.ioc.Err, "printf: %%%s: invalid directive\n", f)
+		p.ex = T.ExitFailure
+		return i, true
+	}
+
+	verb := f[i]
+	spec := "%" + flags + width + precision
+	switch verb {
+	case '%':
+		p.out.WriteByte('%')
+	case 's':
+		arg, _ := p.nextArg()
+		fmt.Fprintf(&p.out, spec+"s", arg)
+	case 'b':
+		arg, _ := p.nextArg()
+		s, stop := unescapeAll(arg)
+		fmt.Fprintf(&p.out, spec+"s", s)
+		if stop {
+			return i + 1, true
+		}
+	case 'c':
+		// An empty argument writes a NUL as the string ends there in C
+		arg, _ := p.nextArg()
+		if arg != "" {
+			arg = string([]rune(arg)[:1])
+		} else {
+			arg = "\x00"
+		}
+		fmt.Fprintf(&p.out, spec+"s", arg)
+	case 'd', 'i':
+		fmt.Fprintf(&p.out, spec+"d", p.intArg())
+	case 'o', 'u', 'x', 'X':
+		// Negative values are converted to unsigned as in C
+		v := uint64(p.intArg())
+		if verb == 'u' {
+			verb = 'd'
+		}
+		fmt.Fprintf(&p.out, spec+string(verb), v)
+	case 'e', 'E', 'f', 'F', 'g', 'G':
+		// C uses a precision of 6 for %g by default where Go uses as
+		// many digits as needed.
+		if precision == "" {
+			spec += ".6"
+		}
+		fmt.Fprintf(&p.out, spec+string(verb), p.floatArg())
+	default:
+		fmt.Fprintf(p.ioc.Err, "printf: %%%c: invalid directive\n", verb)
+		p.ex = T.ExitFailure
+		return i + 1, true
+	}
+	return i + 1, false
+}
+
+// specNumber reads a field width or precision which is either digits or
+// '*' to take it from the next argument.
+func (p *printfState) specNumber(f string) (string, int) {
+	if strings.HasPrefix(f, "*") {
+		return strconv.FormatInt(p.intArg(), 10), 1
+	}
+	i := 0
+	for i < len(f) && f[i] >= '0' && f[i] <= '9' {
+		i++
+	}
+	return f[:i], i
+}
+
+func (p *printfState) intArg() int64 {
+	arg, ok := p.nextArg()
+	if !ok {
+		return 0
+	}
+	if len(arg) > 1 && (arg[0] == '\'' || arg[0] == '"') {
+		return int64([]rune(arg[1:])[0])
+	}
+	var v int64
+	p.numberPrefix(arg, func(s string) (err error) {
+		v, err = parseCInt(s)
+		return err
+	})
+	return v
+}
+
+func (p *printfState) floatArg() float64 {
+	arg, ok := p.nextArg()
+	if !ok {
+		return 0
+	}
+	if len(arg) > 1 && (arg[0] == '\'' || arg[0] == '"') {
+		return float64([]rune(arg[1:])[0])
+	}
+	var v float64
+	p.numberPrefix(arg, func(s string) (err error) {
+		v, err = parseCFloat(s)
+		return err
+	})
+	return v
+}
+
+// parseCInt parses s as C's strtol does with a base of 0. It is a
+// decimal number, octal with a leading '0' or hexadecimal with a leading
+// '0x', after an optional sign. Go's forms such as '0b101' or '1_000' are
+// not accepted.
+func parseCInt(s string) (int64, error) {
+	sign := ""
+	if s != "" && (s[0] == '+' || s[0] == '-') {
+		sign, s = s[:1], s[1:]
+	}
+	base := 10
+	switch {
+	case len(s) > 2 && (s[:2] == "0x" || s[:2] == "0X"):
+		base = 16
+		s = s[2:]
+	case len(s) > 1 && s[0] == '0':
+		base = 8
+		s = s[1:]
+	}
+	if s == "" || s[0] == '+' || s[0] == '-' {
+		return 0, strconv.ErrSyntax
+	}
+	return strconv.ParseInt(sign+s, base, 64)
+}
+
+// parseCFloat parses s as C's strtod does. Go's digit separators are not
+// accepted and a hexadecimal number does not need an exponent.
+func parseCFloat(s string) (float64, error) {
+	if strings.ContainsRune(s, '_') {
+		return 0, strconv.ErrSyntax
+	}
+	digits := strings.TrimLeft(s, "+-")
+	if (strings.HasPrefix(digits, "0x") || strings.HasPrefix(digits, "0X")) && !strings.ContainsAny(digits, "pP") {
+		s += "p0"
+	}
+	return strconv.ParseFloat(s, 64)
+}
+
+// numberPrefix calls parse with the longest prefix of arg it accepts. As
+// in C an argument like '12abc' is converted as far as possible with a
+// diagnostic.
+func (p *printfState) numberPrefix(arg string, parse func(string) error) {
+	s := strings.TrimSpace(arg)
+	for n := len(s); n > 0; n-- {
+		if parse(s[:n]) != nil {
+			continue
+		}
+		if n < len(s) {
+			fmt.Fprintf(p.ioc.Err, "printf: %s: not completely converted\n", arg)
+			p.ex = T.ExitFailure
+		}
+		return
+	}
+	parse("0")
+	fmt.Fprintf(p.ioc.Err, "printf: %s: expected numeric value\n", arg)
+	p.ex = T.ExitFailure
+}
+
+// unescapeAll interprets every backslash escape in s for 'echo -e' and
+// printf's %b. stop is true if '\c' was found, the text after it is
+// dropped.
+func unescapeAll(s string) (string, bool) {
+	buf := bytes.Buffer{}
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			buf.WriteByte(s[i])
+			continue
+		}
+		if strings.HasPrefix(s[i+1:], "c") {
+			return buf.String(), true
+		}
+		esc, n := unescape(s[i+1:], true)
+		buf.WriteString(esc)
+		i += n
+	}
+	return buf.String(), false
+}
+
+// unescape interprets the escape sequence at the start of s, which
+// follows a backslash, returning the result and the number of bytes of s
+// used. In printf formats octal values are written '\NNN', for 'echo -e'
+// and %b they are '\0NNN'. An unknown escape is left as it is.
+func unescape(s string, zeroOctal bool) (string, int) {
+	if s == "" {
+		return "\\", 0
+	}
+	switch s[0] {
+	case '\\':
+		return "\\", 1
+	case 'a':
+		return "\a", 1
+	case 'b':
+		return "\b", 1
+	case 'e':
+		return "\x1b", 1
+	case 'f':
+		return "\f", 1
+	case 'n':
+		return "\n", 1
+	case 'r':
+		return "\r", 1
+	case 't':
+		return "\t", 1
+	case 'v':
+		return "\v", 1
+	case '"', '\'':
+		if !zeroOctal {
+			return s[:1], 1
+		}
+	case 'x':
+		digits := 0
+		for digits < 2 && 1+digits < len(s) && isHexDigit(s[1+digits]) {
+			digits++
+		}
+		if digits > 0 {
+			v, _ := strconv.ParseUint(s[1:1+digits], 16, 8)
+			return string([]byte{byte(v)}), 1 + digits
+		}
+	}
+
+	start := 0
+	if zeroOctal {
+		if s[0] != '0' {
+			return "\\", 0
+		}
+		start = 1
+	}
+	digits := 0
+	for digits < 3 && start+digits < len(s) && s[start+digits] >= '0' && s[start+digits] <= '7' {
+		digits++
+	}
+	if digits == 0 {
+		if zeroOctal {
+			// '\0' on its own is a NUL
+			return "\x00", 1
+		}
+		return "\\", 0
+	}
+	v, _ := strconv.ParseUint(s[start:start+digits], 8, 16)
+	return string([]byte{byte(v)}), start + digits
+}
+
+func isHexDigit(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
@@ -0,0 +1,54 @@
+package builtins
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/danwakefield/gosh/T"
+	"github.com/danwakefield/gosh/variables"
+)
+
+// PwdCmd writes the working directory. By default, or with -L, it is the
+// logical path used to reach it which may contain symbolic links. With -P
+// the links are resolved. If the logical path no longer refers to a
+// directory the physical one is written.
+//
+//	pwd [-L|-P]
+func PwdCmd(scp *variables.Scope, ioc *T.IOContainer, args []string) T.ExitStatus {
+	physical := false
+	for len(args) > 0 && len(args[0]) > 1 && args[0][0] == '-' {
+		arg := args[0]
+		args = args[1:]
+		if arg == "--" {
+			break
+		}
+		for _, c := range arg[1:] {
+			switch c {
+			case 'L':
+				physical = false
+			case 'P':
+				physical = true
+			default:
+				fmt.Fprintf(ioc.Err, "pwd: Illegal option -%c\n", c)
+				return T.ExitUsage
+			}
+		}
+	}
+
+	dir := scp.Pwd
+	if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+		physical = true
+	}
+	if physical {
+		resolved, err := filepath.EvalSymlinks(dir)
+		if err != nil {
+			fmt.Fprintf(ioc.Err, "pwd: %s\n", err.Error())
+			return T.ExitFailure
+		}
+		dir = resolved
+	}
+
+	fmt.Fprintln(ioc.Out, dir)
+	return T.ExitSuccess
+}
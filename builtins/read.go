@@ -0,0 +1,203 @@
+package builtins
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/danwakefield/gosh/T"
+	"github.com/danwakefield/gosh/variables"
+)
+
+// ReadCmd reads a line from standard input and splits it into fields
+// using IFS. Each name is assigned a field with the last name getting
+// the remainder of the line. Without names the whole line is assigned to
+// REPLY. The status is non zero if the end of the input was reached.
+//
+//	read [-r] [-p prompt] [name...]
+//
+// Unless -r is given a backslash removes the special meaning of the next
+// character and a backslash at the end of a line continues it onto the
+// next. -p writes prompt to standard error if the input is a terminal.
+//
+// Input is read a byte at a time so that no more than the line is taken
+// from a pipe that other commands also read.
+func ReadCmd(scp *variables.Scope, ioc *T.IOContainer, args []string) T.ExitStatus {
+	raw := false
+	for len(args) > 0 && len(args[0]) > 1 && args[0][0] == '-' {
+		arg := args[0]
+		args = args[1:]
+		if arg == "--" {
+			break
+		}
+		for i := 1; i < len(arg); i++ {
+			switch arg[i] {
+			case 'r':
+				raw = true
+			case 'p':
+				prompt := arg[i+1:]
+				if prompt == "" {
+					if len(args) == 0 {
+						fmt.Fprintf(ioc.Err, "read: -p: option requires an argument\n")
+						return T.ExitUsage
+					}
+					prompt = args[0]
+					args = args[1:]
+				}
+				if f, isFile := ioc.In.(*os.File); isFile && T.IsTerminal(f) {
+					fmt.Fprint(ioc.Err, prompt)
+				}
+				i = len(arg)
+			default:
+				fmt.Fprintf(ioc.Err, "read: Illegal option -%c\n", arg[i])
+				return T.ExitUsage
+			}
+		}
+	}
+	for _, name := range args {
+		if !variables.IsGoodName(name) {
+			fmt.Fprintf(ioc.Err, "read: %s: bad variable name\n", name)
+			return T.ExitUsage
+		}
+	}
+
+	line, eof, err := readLine(ioc.In, raw)
+	if err != nil {
+		fmt.Fprintf(ioc.Err, "read: %s\n", err.Error())
+		return T.ExitFailure
+	}
+
+	ifs := " \t\n"
+	if v := scp.Get("IFS"); v.Set {
+		ifs = v.Val
+	}
+	names := args
+	values := []string{}
+	if len(names) == 0 {
+		names = []string{"REPLY"}
+		values = append(values, line.String())
+	} else {
+		values = line.split(ifs, len(names))
+	}
+
+	for i, name := range names {
+		val := ""
+		if i < len(values) {
+			val = values[i]
+		}
+		if err := scp.Set(name, val); err != nil {
+			fmt.Fprintf(ioc.Err, "read: %s: %s\n", name, err.Error())
+			return T.ExitFailure
+		}
+	}
+
+	if eof {
+		return T.ExitFailure
+	}
+	return T.ExitSuccess
+}
+
+// readChar is a byte of input and whether it was escaped by a backslash.
+type readChar struct {
+	b       byte
+	escaped bool
+}
+
+type readLineChars []readChar
+
+func (l readLineChars) String() string {
+	b := make([]byte, len(l))
+	for i, c := range l {
+		b[i] = c.b
+	}
+	return string(b)
+}
+
+// readLine reads up to a newline, which is not included. eof is true if
+// the input ended first.
+func readLine(r io.Reader, raw bool) (line readLineChars, eof bool, err error) {
+	buf := make([]byte, 1)
+	escaped := false
+	for {
+		n, err := r.Read(buf)
+		if n == 0 {
+			if err == io.EOF {
+				return line, true, nil
+			}
+			if err != nil {
+				return line, false, err
+			}
+			continue
+		}
+
+		c := buf[0]
+		switch {
+		case escaped:
+			escaped = false
+			// A backslash newline continues the line
+			if c != '\n' {
+				line = append(line, readChar{b: c, escaped: true})
+			}
+		case c == '\\' && !raw:
+			escaped = true
+		case c == '\n':
+			return line, false, nil
+		default:
+			line = append(line, readChar{b: c})
+		}
+	}
+}
+
+// split divides the line into at most n fields on the characters in ifs.
+// The last field is the remainder of the line with IFS whitespace removed
+// from its ends.
+func (l readLineChars) split(ifs string, n int) []string {
+	isIFS := func(c readChar) bool { return !c.escaped && strings.IndexByte(ifs, c.b) != -1 }
+	isWhite := func(c readChar) bool { return isIFS(c) && strings.IndexByte(" \t\n", c.b) != -1 }
+
+	// field reads a field starting at i and the delimiter after it,
+	// returning the index following them.
+	field := func(i int) (readLineChars, int) {
+		start := i
+		for i < len(l) && !isIFS(l[i]) {
+			i++
+		}
+		f := l[start:i]
+		for i < len(l) && isWhite(l[i]) {
+			i++
+		}
+		if i < len(l) && isIFS(l[i]) {
+			i++
+			for i < len(l) && isWhite(l[i]) {
+				i++
+			}
+		}
+		return f, i
+	}
+
+	fields := []string{}
+	i := 0
+	for i < len(l) && isWhite(l[i]) {
+		i++
+	}
+	for len(fields) < n-1 && i < len(l) {
+		var f readLineChars
+		f, i = field(i)
+		fields = append(fields, f.String())
+	}
+	if i >= len(l) {
+		return fields
+	}
+
+	// If the remainder is a single field its trailing delimiter is
+	// dropped, otherwise it is kept as it is.
+	if f, end := field(i); end == len(l) {
+		return append(fields, f.String())
+	}
+	end := len(l)
+	for end > i && isWhite(l[end-1]) {
+		end--
+	}
+	return append(fields, l[i:end].String())
+}
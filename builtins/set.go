@@ -0,0 +1,95 @@
+package builtins
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/danwakefield/gosh/T"
+	"github.com/danwakefield/gosh/jobs"
+	"github.com/danwakefield/gosh/variables"
+)
+
+// SetCmd turns shell options on with '-' or off with '+'. Options can be
+// given by their letter, E.g 'set -f', or name, E.g 'set -o noglob'.
+// Without a name 'set -o' lists the options and 'set +o' prints the
+// commands to restore them.
+// Any arguments following the options replace the positional parameters.
+// 'set --' ends the options so that the parameters can be cleared or start
+// with a '-'.
+//
+//	set [-+options] [-+o name] [--] [args...]
+func SetCmd(scp *variables.Scope, ioc *T.IOContainer, args []string) T.ExitStatus {
+	i := 0
+	for ; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			scp.SetPositionalArgs(args[i+1:])
+			return T.ExitSuccess
+		}
+		if len(arg) < 2 || (arg[0] != '-' && arg[0] != '+') {
+			break
+		}
+		on := arg[0] == '-'
+
+		for _, c := range arg[1:] {
+			if c == 'o' {
+				i++
+				if i >= len(args) {
+					printOptions(scp, ioc, on)
+					return T.ExitSuccess
+				}
+				opt, found := variables.OptionNames[args[i]]
+				if !found {
+					fmt.Fprintf(ioc.Err, "set: Illegal option -o %s\n", args[i])
+					return T.ExitUsage
+				}
+				setOption(scp, opt, on)
+				continue
+			}
+
+			if !variables.IsOptionLetter(c) {
+				fmt.Fprintf(ioc.Err, "set: Illegal option %c%c\n", arg[0], c)
+				return T.ExitUsage
+			}
+			setOption(scp, variables.ShellOption(c), on)
+		}
+	}
+
+	if i < len(args) {
+		scp.SetPositionalArgs(args[i:])
+	}
+	return T.ExitSuccess
+}
+
+func setOption(scp *variables.Scope, opt variables.ShellOption, on bool) {
+	scp.SetOption(opt, on)
+	if opt == variables.OptionMonitor {
+		if on {
+			jobs.EnableControl()
+		} else {
+			jobs.DisableControl()
+		}
+	}
+}
+
+func printOptions(scp *variables.Scope, ioc *T.IOContainer, long bool) {
+	names := []string{}
+	for name := range variables.OptionNames {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		on := scp.Option(variables.OptionNames[name])
+		switch {
+		case long && on:
+			fmt.Fprintf(ioc.Out, "%-16s%s\n", name, "on")
+		case long:
+			fmt.Fprintf(ioc.Out, "%-16s%s\n", name, "off")
+		case on:
+			fmt.Fprintf(ioc.Out, "set -o %s\n", name)
+		default:
+			fmt.Fprintf(ioc.Out, "set +o %s\n", name)
+		}
+	}
+}
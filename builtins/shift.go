@@ -0,0 +1,33 @@
+package builtins
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/danwakefield/gosh/T"
+	"github.com/danwakefield/gosh/variables"
+)
+
+// ShiftCmd removes the first n positional parameters, 1 by default, so
+// that $n+1 becomes $1.
+//
+//	shift [n]
+func ShiftCmd(scp *variables.Scope, ioc *T.IOContainer, args []string) T.ExitStatus {
+	n := 1
+	if len(args) > 0 {
+		var err error
+		n, err = strconv.Atoi(args[0])
+		if err != nil || n < 0 {
+			fmt.Fprintf(ioc.Err, "shift: Illegal number: %s\n", args[0])
+			return T.ExitUsage
+		}
+	}
+
+	params := scp.PositionalArgs()
+	if n > len(params) {
+		fmt.Fprintf(ioc.Err, "shift: can't shift that many\n")
+		return T.ExitFailure
+	}
+	scp.SetPositionalArgs(params[n:])
+	return T.ExitSuccess
+}
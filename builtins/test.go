@@ -0,0 +1,327 @@
+package builtins
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/danwakefield/gosh/T"
+	"github.com/danwakefield/gosh/variables"
+)
+
+// Modes for access(2)
+const (
+	accessExecute = 1
+	accessWrite   = 2
+	accessRead    = 4
+)
+
+// TestCmd evaluates a conditional expression, returning 0 if it is true,
+// 1 if it is false and 2 if it is not valid.
+//
+//	test expression
+//
+// With up to four arguments the expression is interpreted following the
+// POSIX rules based on the number of arguments so that E.g 'test -n' and
+// 'test ! =' work. Longer expressions are parsed using the grammar
+//
+//	expr    = and ('-o' and)*
+//	and     = not ('-a' not)*
+//	not     = '!' not | primary
+//	primary = '(' expr ')' | unary word | word binary word | word
+func TestCmd(scp *variables.Scope, ioc *T.IOContainer, args []string) T.ExitStatus {
+	return runTest("test", scp, ioc, args)
+}
+
+// BracketCmd is TestCmd written as '[ expression ]'.
+func BracketCmd(scp *variables.Scope, ioc *T.IOContainer, args []string) T.ExitStatus {
+	if len(args) == 0 || args[len(args)-1] != "]" {
+		fmt.Fprintf(ioc.Err, "[: missing ]\n")
+		return T.ExitUsage
+	}
+	return runTest("[", scp, ioc, args[:len(args)-1])
+}
+
+func runTest(name string, scp *variables.Scope, ioc *T.IOContainer, args []string) T.ExitStatus {
+	t := &testExpr{scp: scp, ioc: ioc}
+	result, err := t.evalArgs(args)
+	if err != nil {
+		fmt.Fprintf(ioc.Err, "%s: %s\n", name, err.Error())
+		return T.ExitUsage
+	}
+	if result {
+		return T.ExitSuccess
+	}
+	return T.ExitFailure
+}
+
+type testExpr struct {
+	scp  *variables.Scope
+	ioc  *T.IOContainer
+	args []string
+	pos  int
+}
+
+var testUnaryOps = map[string]bool{
+	"-b": true, "-c": true, "-d": true, "-e": true, "-f": true, "-g": true,
+	"-h": true, "-k": true, "-L": true, "-n": true, "-p": true, "-r": true,
+	"-S": true, "-s": true, "-t": true, "-u": true, "-w": true, "-x": true,
+	"-z": true,
+}
+
+var testBinaryOps = map[string]bool{
+	"=": true, "!=": true, "<": true, ">": true,
+	"-eq": true, "-ne": true, "-gt": true, "-ge": true, "-lt": true, "-le": true,
+	"-nt": true, "-ot": true, "-ef": true,
+}
+
+// evalArgs applies the rules for expressions of up to four arguments,
+// falling back to parsing the whole expression.
+func (t *testExpr) evalArgs(args []string) (bool, error) {
+	switch len(args) {
+	case 0:
+		return false, nil
+	case 1:
+		return args[0] != "", nil
+	case 2:
+		if args[0] == "!" {
+			return t.negate(t.evalArgs(args[1:]))
+		}
+		if testUnaryOps[args[0]] {
+			return t.unary(args[0], args[1])
+		}
+		return false, fmt.Errorf("%s: unexpected operator", args[0])
+	case 3:
+		if testBinaryOps[args[1]] {
+			return t.binary(args[0], args[1], args[2])
+		}
+		if args[0] == "!" {
+			return t.negate(t.evalArgs(args[1:]))
+		}
+		if args[0] == "(" && args[2] == ")" {
+			return t.evalArgs(args[1:2])
+		}
+	case 4:
+		if args[0] == "!" {
+			return t.negate(t.evalArgs(args[1:]))
+		}
+		if args[0] == "(" && args[3] == ")" {
+			return t.evalArgs(args[1:3])
+		}
+	}
+
+	t.args = args
+	t.pos = 0
+	result, err := t.or()
+	if err == nil && t.pos < len(t.args) {
+		err = fmt.Errorf("%s: unexpected operator", t.args[t.pos])
+	}
+	return result, err
+}
+
+func (t *testExpr) negate(result bool, err error) (bool, error) {
+	return !result, err
+}
+
+func (t *testExpr) peek() (string, bool) {
+	if t.pos >= len(t.args) {
+		return "", false
+	}
+	return t.args[t.pos], true
+}
+
+func (t *testExpr) or() (bool, error) {
+	result, err := t.and()
+	for err == nil {
+		if arg, ok := t.peek(); !ok || arg != "-o" {
+			break
+		}
+		t.pos++
+		var right bool
+		right, err = t.and()
+		result = result || right
+	}
+	return result, err
+}
+
+func (t *testExpr) and() (bool, error) {
+	result, err := t.not()
+	for err == nil {
+		if arg, ok := t.peek(); !ok || arg != "-a" {
+			break
+		}
+		t.pos++
+		var right bool
+		right, err = t.not()
+		result = result && right
+	}
+	return result, err
+}
+
+func (t *testExpr) not() (bool, error) {
+	if arg, ok := t.peek(); ok && arg == "!" {
+		t.pos++
+		return t.negate(t.not())
+	}
+	return t.primary()
+}
+
+func (t *testExpr) primary() (bool, error) {
+	arg, ok := t.peek()
+	if !ok {
+		return false, fmt.Errorf("argument expected")
+	}
+	remaining := len(t.args) - t.pos
+
+	if remaining >= 3 && testBinaryOps[t.args[t.pos+1]] {
+		t.pos += 3
+		return t.binary(arg, t.args[t.pos-2], t.args[t.pos-1])
+	}
+	if arg == "(" {
+		t.pos++
+		result, err := t.or()
+		if err != nil {
+			return false, err
+		}
+		if closing, ok := t.peek(); !ok || closing != ")" {
+			return false, fmt.Errorf("closing paren expected")
+		}
+		t.pos++
+		return result, nil
+	}
+	if remaining >= 2 && testUnaryOps[arg] {
+		t.pos += 2
+		return t.unary(arg, t.args[t.pos-1])
+	}
+	t.pos++
+	return arg != "", nil
+}
+
+func (t *testExpr) unary(op, operand string) (bool, error) {
+	switch op {
+	case "-n":
+		return operand != "", nil
+	case "-z":
+		return operand == "", nil
+	case "-t":
+		fd, err := parseTestInt(operand)
+		if err != nil {
+			return false, err
+		}
+		f, open := t.ioc.Fd(int(fd))
+		osFile, isFile := f.(*os.File)
+		return open && isFile && T.IsTerminal(osFile), nil
+	}
+
+	if operand == "" {
+		return false, nil
+	}
+	path := t.scp.AbsPath(operand)
+
+	switch op {
+	case "-h", "-L":
+		fi, err := os.Lstat(path)
+		return err == nil && fi.Mode()&os.ModeSymlink != 0, nil
+	case "-r":
+		return syscall.Access(path, accessRead) == nil, nil
+	case "-w":
+		return syscall.Access(path, accessWrite) == nil, nil
+	case "-x":
+		return syscall.Access(path, accessExecute) == nil, nil
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		return false, nil
+	}
+	mode := fi.Mode()
+	switch op {
+	case "-b":
+		return mode&os.ModeDevice != 0 && mode&os.ModeCharDevice == 0, nil
+	case "-c":
+		return mode&os.ModeCharDevice != 0, nil
+	case "-d":
+		return mode.IsDir(), nil
+	case "-e":
+		return true, nil
+	case "-f":
+		return mode.IsRegular(), nil
+	case "-g":
+		return mode&os.ModeSetgid != 0, nil
+	case "-k":
+		return mode&os.ModeSticky != 0, nil
+	case "-p":
+		return mode&os.ModeNamedPipe != 0, nil
+	case "-S":
+		return mode&os.ModeSocket != 0, nil
+	case "-s":
+		return fi.Size() > 0, nil
+	case "-u":
+		return mode&os.ModeSetuid != 0, nil
+	}
+	return false, fmt.Errorf("%s: unexpected operator", op)
+}
+
+func (t *testExpr) binary(left, op, right string) (bool, error) {
+	switch op {
+	case "=":
+		return left == right, nil
+	case "!=":
+		return left != right, nil
+	case "<":
+		return left < right, nil
+	case ">":
+		return left > right, nil
+	case "-nt", "-ot":
+		leftInfo, leftErr := os.Stat(t.scp.AbsPath(left))
+		rightInfo, rightErr := os.Stat(t.scp.AbsPath(right))
+		if op == "-ot" {
+			leftInfo, leftErr, rightInfo, rightErr = rightInfo, rightErr, leftInfo, leftErr
+		}
+		// A file that exists is newer than one that does not
+		if leftErr != nil {
+			return false, nil
+		}
+		return rightErr != nil || leftInfo.ModTime().After(rightInfo.ModTime()), nil
+	case "-ef":
+		leftInfo, leftErr := os.Stat(t.scp.AbsPath(left))
+		rightInfo, rightErr := os.Stat(t.scp.AbsPath(right))
+		return leftErr == nil && rightErr == nil && os.SameFile(leftInfo, rightInfo), nil
+	}
+
+	l, err := parseTestInt(left)
+	if err != nil {
+		return false, err
+	}
+	r, err := parseTestInt(right)
+	if err != nil {
+		return false, err
+	}
+	switch op {
+	case "-eq":
+		return l == r, nil
+	case "-ne":
+		return l != r, nil
+	case "-gt":
+		return l > r, nil
+	case "-ge":
+		return l >= r, nil
+	case "-lt":
+		return l < r, nil
+	case "-le":
+		return l <= r, nil
+	}
+	return false, fmt.Errorf("%s: unexpected operator", op)
+}
+
+// parseTestInt parses an integer operand which may be surrounded by
+// blanks.
+func parseTestInt(s string) (int64, error) {
+	i, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Illegal number: %s", s)
+	}
+	return i, nil
+}
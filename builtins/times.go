@@ -0,0 +1,34 @@
+package builtins
+
+import (
+	"fmt"
+	"syscall"
+	"time"
+
+	"github.com/danwakefield/gosh/T"
+	"github.com/danwakefield/gosh/variables"
+)
+
+// TimesCmd writes the user and system CPU time used by the shell on the
+// first line and by the commands it has waited for on the second.
+//
+//	times
+func TimesCmd(scp *variables.Scope, ioc *T.IOContainer, args []string) T.ExitStatus {
+	for _, who := range []int{syscall.RUSAGE_SELF, syscall.RUSAGE_CHILDREN} {
+		var usage syscall.Rusage
+		if err := syscall.Getrusage(who, &usage); err != nil {
+			fmt.Fprintf(ioc.Err, "times: %s\n", err.Error())
+			return T.ExitFailure
+		}
+		fmt.Fprintf(ioc.Out, "%s %s\n", formatCPUTime(usage.Utime), formatCPUTime(usage.Stime))
+	}
+	return T.ExitSuccess
+}
+
+// formatCPUTime writes tv in the form '1m2.500000s'.
+func formatCPUTime(tv syscall.Timeval) string {
+	d := time.Duration(tv.Nano())
+	minutes := int(d / time.Minute)
+	seconds := (d % time.Minute).Seconds()
+	return fmt.Sprintf("%dm%fs", minutes, seconds)
+}
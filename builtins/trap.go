@@ -0,0 +1,69 @@
+package builtins
+
+import (
+	"fmt"
+	"strconv"
+	"syscall"
+
+	"github.com/danwakefield/gosh/T"
+	"github.com/danwakefield/gosh/char"
+	"github.com/danwakefield/gosh/signals"
+	"github.com/danwakefield/gosh/variables"
+)
+
+// TrapCmd sets the commands run when the shell receives a signal.
+//
+//	trap                  list the traps that are set
+//	trap action cond...   run action on each condition
+//	trap - cond...        reset the conditions to their default action
+//	trap "" cond...       ignore the conditions
+//
+// A condition is a signal name or number, or EXIT (0) for when the shell
+// exits. If the first operand is a number every operand is reset. KILL and
+// STOP cannot be trapped.
+func TrapCmd(scp *variables.Scope, ioc *T.IOContainer, args []string) T.ExitStatus {
+	if len(args) > 0 && args[0] == "--" {
+		args = args[1:]
+	}
+
+	if len(args) == 0 {
+		for _, name := range scp.TrapNames() {
+			action, _ := scp.Trap(name)
+			fmt.Fprintf(ioc.Out, "trap -- %s %s\n", char.Quote(action), name)
+		}
+		return T.ExitSuccess
+	}
+
+	action, conds := args[0], args[1:]
+	reset := action == "-"
+	if _, err := strconv.ParseUint(action, 10, 0); err == nil {
+		reset = true
+		conds = args
+	}
+	if len(conds) == 0 {
+		fmt.Fprintf(ioc.Err, "trap: Usage: trap [action condition...]\n")
+		return T.ExitUsage
+	}
+
+	returnExit := T.ExitSuccess
+	for _, cond := range conds {
+		name := variables.TrapExit
+		if cond != "0" && cond != variables.TrapExit {
+			sig, found := signals.Parse(cond)
+			// KILL and STOP cannot be caught or ignored
+			if !found || sig == syscall.SIGKILL || sig == syscall.SIGSTOP {
+				fmt.Fprintf(ioc.Err, "trap: %s: bad trap\n", cond)
+				returnExit = T.ExitFailure
+				continue
+			}
+			name = signals.Name(sig)
+		}
+
+		if reset {
+			scp.ResetTrap(name)
+		} else {
+			scp.SetTrap(name, action)
+		}
+	}
+	return returnExit
+}
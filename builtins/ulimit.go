@@ -0,0 +1,138 @@
+package builtins
+
+import (
+	"fmt"
+	"strconv"
+	"syscall"
+
+	"github.com/danwakefield/gosh/T"
+	"github.com/danwakefield/gosh/variables"
+)
+
+// rlimInfinity is RLIM_INFINITY as the type of the Rlimit fields.
+const rlimInfinity = ^uint64(0)
+
+type ulimitResource struct {
+	option   byte
+	name     string
+	resource int
+	// unit is the number of bytes, or seconds, in the value written and
+	// read by ulimit.
+	unit uint64
+}
+
+var ulimitResources = []ulimitResource{
+	{'t', "time(seconds)", syscall.RLIMIT_CPU, 1},
+	{'f', "file(blocks)", syscall.RLIMIT_FSIZE, 512},
+	{'d', "data(kbytes)", syscall.RLIMIT_DATA, 1024},
+	{'s', "stack(kbytes)", syscall.RLIMIT_STACK, 1024},
+	{'c', "coredump(blocks)", syscall.RLIMIT_CORE, 512},
+	{'n', "nofiles", syscall.RLIMIT_NOFILE, 1},
+	{'v', "vmemory(kbytes)", syscall.RLIMIT_AS, 1024},
+}
+
+// UlimitCmd writes or sets the resource limits of commands run by the
+// shell. The limits of the shell's process are left as they are.
+//
+//	ulimit [-HS] [-a | -tfdscnv] [limit]
+//
+// The resource defaults to -f, the size of files written in 512 byte
+// blocks. -H uses the hard limit and -S the soft limit. Without either the
+// soft limit is written and both are set. limit is a number or
+// 'unlimited'. -a writes every limit.
+func UlimitCmd(scp *variables.Scope, ioc *T.IOContainer, args []string) T.ExitStatus {
+	hard, soft, all := false, false, false
+	res := ulimitResources[1]
+	for len(args) > 0 && len(args[0]) > 1 && args[0][0] == '-' {
+		arg := args[0]
+		args = args[1:]
+		if arg == "--" {
+			break
+		}
+	options:
+		for i := 1; i < len(arg); i++ {
+			switch arg[i] {
+			case 'H':
+				hard = true
+			case 'S':
+				soft = true
+			case 'a':
+				all = true
+			default:
+				for _, r := range ulimitResources {
+					if r.option == arg[i] {
+						res = r
+						continue options
+					}
+				}
+				fmt.Fprintf(ioc.Err, "ulimit: Illegal option -%c\n", arg[i])
+				return T.ExitUsage
+			}
+		}
+	}
+	if len(args) > 1 || (all && len(args) > 0) {
+		fmt.Fprintf(ioc.Err, "ulimit: too many arguments\n")
+		return T.ExitUsage
+	}
+
+	if len(args) == 0 {
+		resources := []ulimitResource{res}
+		if all {
+			resources = ulimitResources
+		}
+		for _, r := range resources {
+			lim, err := scp.Limit(r.resource)
+			if err != nil {
+				fmt.Fprintf(ioc.Err, "ulimit: %s\n", err.Error())
+				return T.ExitFailure
+			}
+			val := lim.Cur
+			if hard && !soft {
+				val = lim.Max
+			}
+			if all {
+				fmt.Fprintf(ioc.Out, "%-20s %s\n", r.name, formatLimit(val, r.unit))
+			} else {
+				fmt.Fprintln(ioc.Out, formatLimit(val, r.unit))
+			}
+		}
+		return T.ExitSuccess
+	}
+
+	val := rlimInfinity
+	if args[0] != "unlimited" {
+		n, err := strconv.ParseUint(args[0], 10, 64)
+		if err != nil || n > rlimInfinity/res.unit {
+			fmt.Fprintf(ioc.Err, "ulimit: bad number\n")
+			return T.ExitUsage
+		}
+		val = n * res.unit
+	}
+
+	lim, err := scp.Limit(res.resource)
+	if err != nil {
+		fmt.Fprintf(ioc.Err, "ulimit: %s\n", err.Error())
+		return T.ExitFailure
+	}
+	if !hard && !soft {
+		hard, soft = true, true
+	}
+	if hard {
+		lim.Max = val
+	}
+	if soft {
+		lim.Cur = val
+	}
+	if err := scp.SetLimit(res.resource, lim); err != nil {
+		fmt.Fprintf(ioc.Err, "ulimit: error setting limit (%s)\n", err.Error())
+		return T.ExitFailure
+	}
+	return T.ExitSuccess
+}
+
+func formatLimit(val, unit uint64) string {
+	if val == rlimInfinity {
+		return "unlimited"
+	}
+	return strconv.FormatUint(val/unit, 10)
+}
@@ -0,0 +1,168 @@
+package builtins
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/danwakefield/gosh/T"
+	"github.com/danwakefield/gosh/variables"
+)
+
+// UmaskCmd sets the file mode creation mask used for redirections and
+// commands. Without a mode the mask is written in octal, or with -S as the
+// permissions it allows.
+//
+//	umask [-S] [mode]
+//
+// mode is either octal or symbolic as used by chmod, E.g 'u=rwx,g=rx,o='
+// or 'go-w'. A symbolic mode describes the permissions to allow rather
+// than those to remove.
+func UmaskCmd(scp *variables.Scope, ioc *T.IOContainer, args []string) T.ExitStatus {
+	symbolic := false
+	for len(args) > 0 && len(args[0]) > 1 && args[0][0] == '-' {
+		arg := args[0]
+		args = args[1:]
+		if arg == "--" {
+			break
+		}
+		for _, c := range arg[1:] {
+			switch c {
+			case 'S':
+				symbolic = true
+			default:
+				fmt.Fprintf(ioc.Err, "umask: Illegal option -%c\n", c)
+				return T.ExitUsage
+			}
+		}
+	}
+
+	if len(args) == 0 {
+		if symbolic {
+			fmt.Fprintln(ioc.Out, symbolicMode(^scp.Umask&0777))
+		} else {
+			fmt.Fprintf(ioc.Out, "%04o\n", scp.Umask)
+		}
+		return T.ExitSuccess
+	}
+
+	mode := args[0]
+	if mode != "" && mode[0] >= '0' && mode[0] <= '9' {
+		mask, err := strconv.ParseUint(mode, 8, 32)
+		if err != nil || mask > 0777 {
+			fmt.Fprintf(ioc.Err, "umask: Illegal number: %s\n", mode)
+			return T.ExitUsage
+		}
+		scp.Umask = int(mask)
+		return T.ExitSuccess
+	}
+
+	perms, err := applySymbolicMode(mode, ^scp.Umask&0777)
+	if err != nil {
+		fmt.Fprintf(ioc.Err, "umask: %s\n", err.Error())
+		return T.ExitUsage
+	}
+	scp.Umask = ^perms & 0777
+	return T.ExitSuccess
+}
+
+// The shift of the user, group and other permission bits
+var umaskWho = []struct {
+	name  byte
+	shift uint
+}{
+	{'u', 6},
+	{'g', 3},
+	{'o', 0},
+}
+
+// symbolicMode formats perms in the form 'u=rwx,g=rx,o=rx'.
+func symbolicMode(perms int) string {
+	parts := []string{}
+	for _, w := range umaskWho {
+		bits := perms >> w.shift
+		s := string(w.name) + "="
+		if bits&4 != 0 {
+			s += "r"
+		}
+		if bits&2 != 0 {
+			s += "w"
+		}
+		if bits&1 != 0 {
+			s += "x"
+		}
+		parts = append(parts, s)
+	}
+	return strings.Join(parts, ",")
+}
+
+// applySymbolicMode returns perms changed by the comma separated clauses
+// of mode. Each clause is made of who letters 'ugoa', all when none are
+// given, followed by actions of an operator '+-=' and either permission
+// letters 'rwx' or a single who letter to copy its permissions.
+func applySymbolicMode(mode string, perms int) (int, error) {
+	for _, clause := range strings.Split(mode, ",") {
+		i := 0
+		who := 0
+		for ; i < len(clause) && strings.IndexByte("ugoa", clause[i]) != -1; i++ {
+			switch clause[i] {
+			case 'u':
+				who |= 0700
+			case 'g':
+				who |= 0070
+			case 'o':
+				who |= 0007
+			case 'a':
+				who |= 0777
+			}
+		}
+		if who == 0 {
+			who = 0777
+		}
+		if i >= len(clause) {
+			return 0, fmt.Errorf("Illegal mode: %s", mode)
+		}
+
+		for i < len(clause) {
+			op := clause[i]
+			if strings.IndexByte("+-=", op) == -1 {
+				return 0, fmt.Errorf("Illegal mode: %s", mode)
+			}
+			i++
+
+			// The permissions are collected as rwx and then repeated
+			// for each of user, group and other.
+			rwx := 0
+			if i < len(clause) && strings.IndexByte("ugo", clause[i]) != -1 {
+				for _, w := range umaskWho {
+					if w.name == clause[i] {
+						rwx = (perms >> w.shift) & 7
+					}
+				}
+				i++
+			} else {
+				for ; i < len(clause) && strings.IndexByte("rwx", clause[i]) != -1; i++ {
+					switch clause[i] {
+					case 'r':
+						rwx |= 4
+					case 'w':
+						rwx |= 2
+					case 'x':
+						rwx |= 1
+					}
+				}
+			}
+			bits := (rwx<<6 | rwx<<3 | rwx) & who
+
+			switch op {
+			case '+':
+				perms |= bits
+			case '-':
+				perms &^= bits
+			case '=':
+				perms = perms&^who | bits
+			}
+		}
+	}
+	return perms, nil
+}
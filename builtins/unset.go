@@ -0,0 +1,47 @@
+package builtins
+
+import (
+	"fmt"
+
+	"github.com/danwakefield/gosh/T"
+	"github.com/danwakefield/gosh/variables"
+)
+
+// UnsetCmd removes variables, or functions with -f. Readonly variables
+// cannot be unset.
+//
+//	unset [-fv] name...
+func UnsetCmd(scp *variables.Scope, ioc *T.IOContainer, args []string) T.ExitStatus {
+	functions := false
+	for len(args) > 0 && len(args[0]) > 1 && args[0][0] == '-' {
+		arg := args[0]
+		args = args[1:]
+		if arg == "--" {
+			break
+		}
+		for _, c := range arg[1:] {
+			switch c {
+			case 'f':
+				functions = true
+			case 'v':
+				functions = false
+			default:
+				fmt.Fprintf(ioc.Err, "unset: Illegal option -%c\n", c)
+				return T.ExitUsage
+			}
+		}
+	}
+
+	ex := T.ExitSuccess
+	for _, name := range args {
+		if functions {
+			delete(scp.Functions, name)
+			continue
+		}
+		if err := scp.Unset(name); err != nil {
+			fmt.Fprintf(ioc.Err, "unset: %s: %s\n", name, err.Error())
+			ex = T.ExitFailure
+		}
+	}
+	return ex
+}
@@ -0,0 +1,81 @@
+package builtins
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/danwakefield/gosh/T"
+	"github.com/danwakefield/gosh/jobs"
+	"github.com/danwakefield/gosh/signals"
+	"github.com/danwakefield/gosh/variables"
+)
+
+// WaitCmd waits for background jobs to finish. Without arguments it waits
+// for every job that is not stopped, reporting those that are, and
+// returns zero. Otherwise it returns the exit status of
+// the last pid or job spec, E.g '%1', given. A pid that is not a job of
+// this shell gives 127. A signal with a trap set interrupts the wait and
+// gives 128 plus the signal number so the trap can run.
+func WaitCmd(scp *variables.Scope, ioc *T.IOContainer, args []string) T.ExitStatus {
+	if len(args) == 0 {
+		for _, j := range scp.Jobs.All() {
+			ex, interrupted, stopped := waitJob(scp, j, true)
+			if interrupted {
+				return ex
+			}
+			if stopped {
+				fmt.Fprintf(ioc.Err, "wait: warning: job %d[%d] stopped\n", j.ID, j.Pid())
+				continue
+			}
+			scp.Jobs.Remove(j)
+		}
+		return T.ExitSuccess
+	}
+
+	returnExit := T.ExitSuccess
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "%") {
+			pid, err := strconv.Atoi(arg)
+			if err != nil || pid <= 0 {
+				fmt.Fprintf(ioc.Err, "wait: Illegal number: %s\n", arg)
+				return T.ExitUsage
+			}
+		}
+
+		j, err := scp.Jobs.Find(arg)
+		if err != nil {
+			returnExit = T.ExitUnknownCommand
+			continue
+		}
+		ex, interrupted, _ := waitJob(scp, j, false)
+		if interrupted {
+			return ex
+		}
+		returnExit = ex
+		scp.Jobs.Remove(j)
+	}
+	return returnExit
+}
+
+// waitJob waits for j to finish, returning its status. interrupted is set
+// if a signal with a trap arrived first. With skipStopped it also returns,
+// with stopped set, once the job is stopped.
+func waitJob(scp *variables.Scope, j *jobs.Job, skipStopped bool) (ex T.ExitStatus, interrupted, stopped bool) {
+	for {
+		arrived := signals.Arrived()
+		changed := j.Changed()
+		if sig, pending := scp.HasPendingSignal(); pending {
+			return T.ExitStatus(128 + int(sig)), true, false
+		}
+		if skipStopped && j.Stopped() {
+			return T.ExitSuccess, false, true
+		}
+		select {
+		case <-j.Finished():
+			return j.Wait(), false, false
+		case <-arrived:
+		case <-changed:
+		}
+	}
+}
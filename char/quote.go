@@ -0,0 +1,23 @@
+package char
+
+import "strings"
+
+// Quote returns s single quoted so the shell reads it back as one word.
+// Single quotes in s are closed, escaped and reopened.
+func Quote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// QuoteIfNeeded quotes s only if it is empty or contains characters that
+// are special to the shell.
+func QuoteIfNeeded(s string) string {
+	if s == "" {
+		return Quote(s)
+	}
+	for _, r := range s {
+		if !IsAlnum(r) && strings.IndexRune("_-+=/.,:@%", r) == -1 {
+			return Quote(s)
+		}
+	}
+	return s
+}
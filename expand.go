@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bytes"
+	"os/user"
+	"strings"
+
+	"gopkg.in/logex.v1"
+
+	"github.com/danwakefield/gosh/T"
+	"github.com/danwakefield/gosh/variables"
+)
+
+const DefaultIFS = " \t\n"
+
+type Arg struct {
+	Raw    string
+	Quoted bool
+	Subs   []Substitution
+}
+
+type ExpandFlag int
+
+const (
+	NoExpandSubstitutions ExpandFlag = iota
+	NoExpandTilde
+	NoExpandWordSplit
+	NoExpandGlob
+)
+
+// expandedRune is a single character resulting from expansion. We have to
+// keep track of where each character came from as only the results of
+// unquoted substitutions are subject to field splitting and quoted
+// characters are never part of a pattern.
+type expandedRune struct {
+	r      rune
+	quoted bool
+	split  bool
+	// fieldBreak separates the positional parameters expanded from '$@'.
+	// It has no character of its own.
+	fieldBreak bool
+}
+
+type field []expandedRune
+
+func (f field) String() string {
+	buf := bytes.Buffer{}
+	for _, er := range f {
+		buf.WriteRune(er.r)
+	}
+	return buf.String()
+}
+
+// Expand performs tilde expansion, substitutions, field splitting and
+// pathname expansion on the Arg and returns the resulting fields.
+// An unquoted Arg that expands to nothing returns no fields.
+func (a Arg) Expand(scp *variables.Scope, ioc *T.IOContainer, flags ...ExpandFlag) (fields []string) {
+	flagSet := func(e ExpandFlag) bool {
+		for _, v := range flags {
+			if v == e {
+				return true
+			}
+		}
+		return false
+	}
+
+	logex.Debugf("Expand '%s'", a.Raw)
+	defer func() {
+		logex.Debugf("Returned '%s'", fields)
+	}()
+
+	expString := a.Raw
+
+	if !flagSet(NoExpandTilde) {
+		expString = a.expandTilde(scp, expString)
+	}
+
+	runes, emptyAt := a.expandSubstitutions(scp, ioc, expString, !flagSet(NoExpandSubstitutions))
+
+	var splitFields []field
+	if flagSet(NoExpandWordSplit) {
+		if len(runes) > 0 {
+			splitFields = []field{runes.joinBreaks()}
+		}
+	} else {
+		splitFields = splitIFS(runes, scp)
+	}
+
+	// A quoted empty string is kept as an empty field. E.g "" or "$EMPTY"
+	// but '"$@"' without positional parameters expands to nothing.
+	if len(splitFields) == 0 && a.Quoted && !emptyAt {
+		return []string{""}
+	}
+
+	fields = []string{}
+	if !flagSet(NoExpandGlob) && !scp.Option(variables.OptionNoGlob) {
+		for _, f := range splitFields {
+			fields = append(fields, expandPathname(scp, f)...)
+		}
+		return fields
+	}
+
+	for _, f := range splitFields {
+		fields = append(fields, f.String())
+	}
+	return fields
+}
+
+// ExpandString expands the Arg into a single string. Field splitting and
+// pathname expansion are not performed. This is used where the shell
+// expects a single word E.g assignments and redirection targets.
+func (a Arg) ExpandString(scp *variables.Scope, ioc *T.IOContainer, flags ...ExpandFlag) string {
+	flags = append(flags, NoExpandWordSplit, NoExpandGlob)
+	return strings.Join(a.Expand(scp, ioc, flags...), " ")
+}
+
+// ExpandPattern expands the Arg into a pattern for fnmatch. Quoted
+// characters are escaped so that they only match themselves.
+// E.g the pattern in 'case $x in "*")' only matches a literal '*'
+func (a Arg) ExpandPattern(scp *variables.Scope, ioc *T.IOContainer) string {
+	runes, _ := a.expandSubstitutions(scp, ioc, a.expandTilde(scp, a.Raw), true)
+	pattern, _ := runes.joinBreaks().Pattern()
+	return pattern
+}
+
+// expandSubstitutions replaces each SentinalSubstitution with the result of
+// the matching Substitution and removes the SentinalEscape markers used to
+// indicate quoting. emptyAt reports a quoted '$@' that expanded to nothing.
+func (a Arg) expandSubstitutions(scp *variables.Scope, ioc *T.IOContainer, s string, doSubs bool) (runes field, emptyAt bool) {
+	runes = field{}
+	subCounter := 0
+	escaped := false
+
+	for _, r := range s {
+		switch {
+		case r == SentinalEscape && !escaped:
+			escaped = true
+			continue
+		case r == SentinalSubstitution:
+			if !doSubs {
+				subCounter++
+				break
+			}
+			sub := a.Subs[subCounter]
+			if sv, ok := sub.(SubVariable); ok && sv.expandsToFields(escaped) {
+				params := scp.PositionalArgs()
+				emptyAt = emptyAt || (escaped && len(params) == 0)
+				runes = append(runes, paramFields(params, escaped)...)
+			} else {
+				for _, subR := range sub.Sub(scp, ioc) {
+					runes = append(runes, expandedRune{r: subR, quoted: escaped, split: !escaped})
+				}
+			}
+			subCounter++
+		default:
+			runes = append(runes, expandedRune{r: r, quoted: escaped})
+		}
+		escaped = false
+	}
+
+	return runes, emptyAt
+}
+
+// paramFields returns the positional parameters separated by field
+// breaks so that each becomes a field of its own.
+func paramFields(params []string, quoted bool) field {
+	runes := field{}
+	for i, p := range params {
+		if i > 0 {
+			runes = append(runes, expandedRune{quoted: quoted, fieldBreak: true})
+		}
+		for _, r := range p {
+			runes = append(runes, expandedRune{r: r, quoted: quoted, split: !quoted})
+		}
+	}
+	return runes
+}
+
+// joinBreaks replaces field breaks with spaces where the shell wants a
+// single word. E.g 'A="$@"'
+func (f field) joinBreaks() field {
+	joined := field{}
+	for _, er := range f {
+		if er.fieldBreak {
+			er = expandedRune{r: ' ', quoted: er.quoted, split: !er.quoted}
+		}
+		joined = append(joined, er)
+	}
+	return joined
+}
+
+func (a Arg) expandTilde(scp *variables.Scope, s string) string {
+	if strings.HasPrefix(s, "~") && !a.Quoted {
+		u, err := user.Current()
+		if err != nil {
+			return s
+		}
+		return u.HomeDir + s[1:]
+	} else {
+		return s
+	}
+}
+
+// splitIFS performs field splitting on the characters that resulted from
+// unquoted substitutions using the characters in the IFS variable as
+// delimiters.
+//
+// IFS whitespace (space, tab and newline) is ignored at the start and end
+// of the input and a sequence of it acts as a single delimiter. Any other
+// IFS character, along with adjacent IFS whitespace, delimits a single
+// field so "a::b" with IFS=":" results in "a", "" and "b".
+func splitIFS(runes field, scp *variables.Scope) []field {
+	ifs := DefaultIFS
+	if v := scp.Get("IFS"); v.Set {
+		ifs = v.Val
+	}
+
+	fields := []field{}
+	cur := field{}
+	// haveField is needed as well as len(cur) since a non-whitespace
+	// delimiter can produce empty fields.
+	haveField := false
+	// inDelimiter is true once a field has been ended by a delimiter and
+	// until the next non-delimiter character. nonWhitespaceSeen records if
+	// the current delimiter has used its single non-whitespace character.
+	inDelimiter := false
+	nonWhitespaceSeen := false
+
+	for _, er := range runes {
+		if er.fieldBreak {
+			// Quoted parameters are always fields, even when empty.
+			// Unquoted ones are delimited like IFS whitespace.
+			if er.quoted || haveField {
+				fields = append(fields, cur)
+			}
+			cur = field{}
+			haveField = er.quoted
+			inDelimiter = !er.quoted
+			nonWhitespaceSeen = false
+			continue
+		}
+		if !er.split || !strings.ContainsRune(ifs, er.r) {
+			inDelimiter = false
+			nonWhitespaceSeen = false
+			cur = append(cur, er)
+			haveField = true
+			continue
+		}
+
+		if strings.ContainsRune(DefaultIFS, er.r) {
+			if haveField {
+				fields = append(fields, cur)
+				cur = field{}
+				haveField = false
+				inDelimiter = true
+				nonWhitespaceSeen = false
+			}
+			continue
+		}
+
+		if inDelimiter && !nonWhitespaceSeen {
+			// This character is part of the delimiter started by
+			// whitespace which has already ended the field.
+			nonWhitespaceSeen = true
+			continue
+		}
+		fields = append(fields, cur)
+		cur = field{}
+		haveField = false
+		inDelimiter = true
+		nonWhitespaceSeen = true
+	}
+
+	if haveField {
+		fields = append(fields, cur)
+	}
+	return fields
+}
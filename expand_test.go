@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/danwakefield/gosh/T"
+	"github.com/danwakefield/gosh/variables"
+)
+
+func TestSplitIFS(t *testing.T) {
+	cases := []struct {
+		ifs  string
+		in   string
+		want []string
+	}{
+		{DefaultIFS, "a b", []string{"a", "b"}},
+		{DefaultIFS, "  a \t\n b  ", []string{"a", "b"}},
+		{DefaultIFS, "   ", []string{}},
+		{":", "a::b:", []string{"a", "", "b"}},
+		{":", ":a", []string{"", "a"}},
+		{" :", "a : b  c:", []string{"a", "b", "c"}},
+		{" :", "a: :b", []string{"a", "", "b"}},
+		{"", "a b", []string{"a b"}},
+	}
+
+	for _, c := range cases {
+		scp := variables.NewScope()
+		scp.Set("IFS", c.ifs)
+
+		in := field{}
+		for _, r := range c.in {
+			in = append(in, expandedRune{r: r, split: true})
+		}
+
+		got := []string{}
+		for _, f := range splitIFS(in, scp) {
+			got = append(got, f.String())
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("splitIFS(%q) with IFS=%q should be %q not %q", c.in, c.ifs, c.want, got)
+		}
+	}
+}
+
+func TestSplitIFSOnlySplitsSubstitutions(t *testing.T) {
+	scp := variables.NewScope()
+	in := field{
+		{r: 'a'}, {r: ' '}, {r: 'b', split: true}, {r: ' ', split: true},
+		{r: 'c', split: true}, {r: ' ', quoted: true}, {r: 'd'},
+	}
+
+	got := []string{}
+	for _, f := range splitIFS(in, scp) {
+		got = append(got, f.String())
+	}
+	want := []string{"a b", "c d"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("splitIFS should be %q not %q", want, got)
+	}
+}
+
+func TestFieldPattern(t *testing.T) {
+	cases := []struct {
+		in        field
+		want      string
+		isPattern bool
+	}{
+		{field{{r: 'a'}, {r: '*'}}, "a*", true},
+		{field{{r: 'a'}, {r: '*', quoted: true}}, `a\*`, false},
+		{field{{r: '?', split: true}}, "?", true},
+		{field{{r: '[', quoted: true}, {r: '\\', quoted: true}}, `\[\\`, false},
+	}
+
+	for _, c := range cases {
+		got, isPattern := c.in.Pattern()
+		if got != c.want || isPattern != c.isPattern {
+			t.Errorf("Pattern of %q should be (%q, %t) not (%q, %t)", c.in.String(), c.want, c.isPattern, got, isPattern)
+		}
+	}
+}
+
+func TestExpandPositionalParameters(t *testing.T) {
+	scp := variables.NewScope()
+	ioc := &T.IOContainer{Err: &bytes.Buffer{}}
+	scp.SetPositionalArgs([]string{"a b", "", "c"})
+	at := []Substitution{SubVariable{VarName: "@"}}
+	star := []Substitution{SubVariable{VarName: "*"}}
+	sub := string(SentinalSubstitution)
+	quotedSub := string(SentinalEscape) + sub
+
+	cases := []struct {
+		arg  Arg
+		want []string
+	}{
+		{Arg{Raw: quotedSub, Quoted: true, Subs: at}, []string{"a b", "", "c"}},
+		{Arg{Raw: "x" + quotedSub + "y", Quoted: true, Subs: at}, []string{"xa b", "", "cy"}},
+		{Arg{Raw: sub, Subs: at}, []string{"a", "b", "c"}},
+		{Arg{Raw: quotedSub, Quoted: true, Subs: star}, []string{"a b  c"}},
+		{Arg{Raw: sub, Subs: star}, []string{"a", "b", "c"}},
+	}
+	for _, c := range cases {
+		if got := c.arg.Expand(scp, ioc, NoExpandGlob); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("Expanding %q should give %q not %q", c.arg.Raw, c.want, got)
+		}
+	}
+
+	scp.SetPositionalArgs(nil)
+	if got := (Arg{Raw: quotedSub, Quoted: true, Subs: at}).Expand(scp, ioc); len(got) != 0 {
+		t.Errorf("\"$@\" without parameters should give no fields, got %q", got)
+	}
+}
+
+func TestExpandErrorWrittenToIOContainer(t *testing.T) {
+	scp := variables.NewScope()
+	scp.SetOption(variables.OptionNoUnset, true)
+	errOut := &bytes.Buffer{}
+	ioc := &T.IOContainer{Err: errOut}
+
+	a := Arg{Raw: string(SentinalSubstitution), Subs: []Substitution{SubVariable{VarName: "NOTSET"}}}
+	a.Expand(scp, ioc)
+	if !strings.Contains(errOut.String(), "NOTSET: Parameter not set") {
+		t.Errorf("The error should be written to ioc.Err, got %q", errOut.String())
+	}
+	if c, _ := scp.Control(); c != variables.ControlExit {
+		t.Errorf("Expanding an unset variable with 'set -u' should exit the shell")
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/danwakefield/fnmatch"
+	"github.com/danwakefield/gosh/variables"
+)
+
+// Pattern returns the field as a pattern suitable for fnmatch. Quoted
+// characters that are special in patterns are escaped so they only match
+// themselves. The bool is false if the field has no unquoted pattern
+// characters and so can only match itself.
+func (f field) Pattern() (string, bool) {
+	buf := bytes.Buffer{}
+	isPattern := false
+
+	for _, er := range f {
+		if er.quoted {
+			if strings.ContainsRune("*?[]\\", er.r) {
+				buf.WriteRune('\\')
+			}
+		} else if strings.ContainsRune("*?[", er.r) {
+			isPattern = true
+		}
+		buf.WriteRune(er.r)
+	}
+
+	return buf.String(), isPattern
+}
+
+// expandPathname performs pathname expansion on a field. Relative patterns
+// are matched against the working directory of the scope. The matching
+// paths are returned sorted. If the field is not a pattern or nothing
+// matches it is returned unchanged.
+func expandPathname(scp *variables.Scope, f field) []string {
+	pattern, isPattern := f.Pattern()
+	if !isPattern {
+		return []string{f.String()}
+	}
+
+	matches := globPattern(scp, pattern)
+	if len(matches) == 0 {
+		return []string{f.String()}
+	}
+	sort.Strings(matches)
+	return matches
+}
+
+// globPattern walks the filesystem one path segment at a time returning
+// all paths that match pattern.
+// Segments without pattern characters are checked for existence rather than
+// read from the directory. As with other shells files beginning with a '.'
+// are only matched when the segment pattern begins with an explicit '.'.
+func globPattern(scp *variables.Scope, pattern string) []string {
+	// Each match is a path matching the segments seen so far. Every match
+	// ends with a '/' when there are more segments to process.
+	matches := []string{""}
+	if strings.HasPrefix(pattern, "/") {
+		matches = []string{"/"}
+		pattern = pattern[1:]
+	}
+
+	segments := strings.Split(pattern, "/")
+	for i, seg := range segments {
+		isLast := i == len(segments)-1
+		next := []string{}
+
+		for _, m := range matches {
+			// Matches are kept relative if the pattern was, we only
+			// use the absolute path when accessing the filesystem.
+			dir := scp.AbsPath(m)
+
+			if seg == "" {
+				// A trailing or repeated '/' only matches directories
+				if isDir(dir) {
+					next = append(next, m)
+				}
+				continue
+			}
+
+			segPattern := strings.ContainsAny(seg, "*?[")
+			names := []string{unescapePattern(seg)}
+			if segPattern {
+				names = readDirNames(dir)
+			}
+
+			for _, name := range names {
+				if segPattern && !fnmatch.Match(seg, name, fnmatch.FNM_PERIOD) {
+					continue
+				}
+				path := m + name
+				if !segPattern {
+					if _, err := os.Lstat(scp.AbsPath(path)); err != nil {
+						continue
+					}
+				}
+				if !isLast {
+					if !isDir(scp.AbsPath(path)) {
+						continue
+					}
+					path += "/"
+				}
+				next = append(next, path)
+			}
+		}
+
+		matches = next
+	}
+
+	return matches
+}
+
+// unescapePattern removes the backslashes used to quote characters in a
+// pattern.
+func unescapePattern(pattern string) string {
+	buf := bytes.Buffer{}
+	escaped := false
+	for _, r := range pattern {
+		if r == '\\' && !escaped {
+			escaped = true
+			continue
+		}
+		escaped = false
+		buf.WriteRune(r)
+	}
+	return buf.String()
+}
+
+func readDirNames(dir string) []string {
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	names, err := f.Readdirnames(-1)
+	if err != nil {
+		return nil
+	}
+	return names
+}
+
+func isDir(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && fi.IsDir()
+}
@@ -0,0 +1,132 @@
+//go:build linux && (amd64 || arm64)
+// +build linux
+// +build amd64 arm64
+
+package jobs
+
+import (
+	"os"
+	"os/signal"
+	"runtime"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// Terminal state used while job control is enabled.
+var (
+	controlMu sync.Mutex
+	tty       *os.File
+	shellPgid int
+	ignored   chan os.Signal
+)
+
+// EnableControl starts job control. The shell stops being suspended by
+// the terminal so that only the foreground job is affected by ^Z.
+func EnableControl() {
+	controlMu.Lock()
+	defer controlMu.Unlock()
+
+	if ignored != nil {
+		return
+	}
+	shellPgid = syscall.Getpgrp()
+	if f, err := os.OpenFile("/dev/tty", os.O_RDWR, 0); err == nil {
+		tty = f
+	}
+	// The signals are caught rather than ignored as ignored signals are
+	// inherited by child processes.
+	ignored = make(chan os.Signal, 1)
+	signal.Notify(ignored, syscall.SIGTSTP, syscall.SIGTTIN, syscall.SIGTTOU)
+}
+
+// DisableControl stops job control started by EnableControl.
+func DisableControl() {
+	controlMu.Lock()
+	defer controlMu.Unlock()
+
+	if ignored == nil {
+		return
+	}
+	signal.Stop(ignored)
+	ignored = nil
+	if tty != nil {
+		tty.Close()
+		tty = nil
+	}
+}
+
+// SetForeground makes pgid the foreground process group of the terminal.
+// It does nothing if the shell has no controlling terminal.
+func SetForeground(pgid int) error {
+	controlMu.Lock()
+	defer controlMu.Unlock()
+
+	if tty == nil || pgid == 0 {
+		return nil
+	}
+
+	// tcsetpgrp from a background process group raises SIGTTOU unless it
+	// is blocked, which can only be done for the current thread.
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	var set, old uint64 = 1 << (uint(syscall.SIGTTOU) - 1), 0
+	syscall.RawSyscall6(syscall.SYS_RT_SIGPROCMASK, sigBlock,
+		uintptr(unsafe.Pointer(&set)), uintptr(unsafe.Pointer(&old)), 8, 0, 0)
+	defer syscall.RawSyscall6(syscall.SYS_RT_SIGPROCMASK, sigSetmask,
+		uintptr(unsafe.Pointer(&old)), 0, 8, 0, 0)
+
+	p := int32(pgid)
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, tty.Fd(), syscall.TIOCSPGRP, uintptr(unsafe.Pointer(&p)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// RestoreForeground gives the terminal back to the shell.
+func RestoreForeground() error {
+	return SetForeground(shellPgid)
+}
+
+// siginfo is the start of the siginfo_t filled in by waitid(2) on 64 bit
+// Linux.
+type siginfo struct {
+	Signo  int32
+	Errno  int32
+	Code   int32
+	_      int32
+	Pid    int32
+	Uid    uint32
+	Status int32
+	_      [100]byte
+}
+
+const (
+	pPid       = 1
+	cldStopped = 5
+	sigBlock   = 0
+	sigSetmask = 2
+)
+
+// WaitStopped blocks until the process pid exits or is stopped. If it was
+// stopped the signal responsible is returned. An exited process is left
+// to be reaped by os/exec.
+func WaitStopped(pid int) (stopped bool, sig syscall.Signal) {
+	for {
+		var info siginfo
+		_, _, errno := syscall.Syscall6(syscall.SYS_WAITID, pPid, uintptr(pid),
+			uintptr(unsafe.Pointer(&info)), syscall.WEXITED|syscall.WSTOPPED|syscall.WNOWAIT, 0, 0)
+		if errno == syscall.EINTR {
+			continue
+		}
+		if errno != 0 || info.Code != cldStopped {
+			return false, 0
+		}
+
+		// Consume the stop so the next call waits for a new change.
+		syscall.Syscall6(syscall.SYS_WAITID, pPid, uintptr(pid),
+			uintptr(unsafe.Pointer(&info)), syscall.WSTOPPED|syscall.WNOHANG, 0, 0)
+		return true, syscall.Signal(info.Status)
+	}
+}
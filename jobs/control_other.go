@@ -0,0 +1,19 @@
+//go:build !linux || !(amd64 || arm64)
+// +build !linux !amd64,!arm64
+
+package jobs
+
+import "syscall"
+
+// Job control needs waitid(2) and is only supported on 64 bit Linux, where
+// the layout of siginfo_t is known. Elsewhere commands run without their
+// own process groups and are never seen to stop.
+
+func EnableControl()  {}
+func DisableControl() {}
+
+func SetForeground(pgid int) error { return nil }
+
+func RestoreForeground() error { return nil }
+
+func WaitStopped(pid int) (stopped bool, sig syscall.Signal) { return false, 0 }
@@ -0,0 +1,471 @@
+// Package jobs keeps track of commands run asynchronously with '&' and,
+// when job control is enabled, pipelines that have been stopped.
+package jobs
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/danwakefield/gosh/T"
+)
+
+var (
+	ErrNoSuchJob    = errors.New("No such job")
+	ErrAmbiguousJob = errors.New("Ambiguous job spec")
+)
+
+// SyntheticPidBase is larger than any pid Linux will allocate. Jobs that
+// never start a process are given a pid above it so that 'kill $!' fails
+// rather than signalling an unrelated process.
+const SyntheticPidBase = 1 << 22
+
+// Job is a command running asynchronously. Its pid is that of the first
+// process it starts so the common 'cmd & kill $!' works as expected.
+type Job struct {
+	ID int
+	// Command is the text of the job shown by the jobs builtin.
+	Command string
+	// Foreground is set for pipelines run in the foreground with job
+	// control enabled. They are only added to a Table if they are stopped.
+	Foreground bool
+
+	startMu sync.Mutex
+	mu      sync.Mutex
+	changed *sync.Cond
+	// changedCh is closed the next time the job stops, continues or
+	// finishes.
+	changedCh chan struct{}
+	pid       int
+	pgid      int
+	running   int
+	detached  bool
+	stopped   bool
+	finished  bool
+	status    T.ExitStatus
+	ready     chan struct{}
+	done      chan struct{}
+}
+
+func NewJob(command string) *Job {
+	j := &Job{
+		Command: command,
+		ready:   make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	j.changed = sync.NewCond(&j.mu)
+	return j
+}
+
+// SetPid records the pid of the job if it does not already have one.
+// A pid of 0 assigns a synthetic pid. Calling SetPid on a nil Job does
+// nothing so commands that are not part of a job can call it freely.
+func (j *Job) SetPid(pid int) {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.setPid(pid)
+}
+
+func (j *Job) setPid(pid int) {
+	if j.pid != 0 {
+		return
+	}
+	if pid == 0 {
+		pid = SyntheticPidBase + j.ID
+	}
+	j.pid = pid
+	close(j.ready)
+}
+
+// Pid returns the pid of the job, waiting until it has been assigned.
+func (j *Job) Pid() int {
+	<-j.ready
+	return j.pid
+}
+
+// Pgid returns the process group of the job or 0 if it has not started a
+// process in its own group.
+func (j *Job) Pgid() int {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.pgid
+}
+
+// StartProcess calls start to start a process for the job. With ownGroup
+// the first process becomes the leader of the job's process group and
+// start is given its pgid, 0 when a new group is needed. Processes are
+// started one at a time so the concurrent commands of a pipeline agree on
+// the group. Once every process of the group has exited the next process
+// leads a new one. Calling StartProcess on a nil Job simply calls start.
+func (j *Job) StartProcess(start func(pgid int) (int, error), ownGroup bool) error {
+	if j == nil {
+		_, err := start(0)
+		return err
+	}
+	j.startMu.Lock()
+	defer j.startMu.Unlock()
+
+	j.mu.Lock()
+	pgid := j.pgid
+	if j.running == 0 {
+		pgid = 0
+	}
+	j.mu.Unlock()
+
+	pid, err := start(pgid)
+	if err != nil {
+		return err
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.setPid(pid)
+	if ownGroup && pgid == 0 {
+		j.pgid = pid
+	}
+	j.running++
+	return nil
+}
+
+// ProcessExited records that a process of the job has finished. The status
+// of a job that was stopped in the foreground is that of its last process
+// to exit.
+func (j *Job) ProcessExited(ex T.ExitStatus) {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.running--
+	j.status = ex
+	if j.detached && j.running == 0 {
+		j.finish(ex)
+	}
+}
+
+// Detach is called once the shell stops waiting for a foreground job that
+// has been stopped. The job finishes when its remaining processes exit.
+func (j *Job) Detach() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.detached = true
+	if j.running == 0 {
+		j.finish(j.status)
+	}
+}
+
+// SetStopped marks the job as stopped by a signal or continued.
+func (j *Job) SetStopped(stopped bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.stopped = stopped
+	j.notifyChanged()
+}
+
+func (j *Job) notifyChanged() {
+	j.changed.Broadcast()
+	if j.changedCh != nil {
+		close(j.changedCh)
+		j.changedCh = nil
+	}
+}
+
+// Changed returns a channel that is closed the next time the job is
+// stopped, continued or finishes.
+func (j *Job) Changed() <-chan struct{} {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.changedCh == nil {
+		j.changedCh = make(chan struct{})
+	}
+	return j.changedCh
+}
+
+// Stopped reports whether the job is stopped.
+func (j *Job) Stopped() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.stopped
+}
+
+// Continue sends SIGCONT to the process group of the job.
+func (j *Job) Continue() error {
+	j.mu.Lock()
+	pgid := j.pgid
+	j.stopped = false
+	j.notifyChanged()
+	j.mu.Unlock()
+
+	if pgid == 0 {
+		return nil
+	}
+	return syscall.Kill(-pgid, syscall.SIGCONT)
+}
+
+// Finish records the exit status of the job and wakes anything waiting
+// for it.
+func (j *Job) Finish(ex T.ExitStatus) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.finish(ex)
+}
+
+func (j *Job) finish(ex T.ExitStatus) {
+	j.setPid(0)
+	j.status = ex
+	j.stopped = false
+	j.finished = true
+	j.notifyChanged()
+	close(j.done)
+}
+
+// Wait blocks until the job has finished and returns its exit status.
+func (j *Job) Wait() T.ExitStatus {
+	<-j.done
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.status
+}
+
+// Finished returns a channel that is closed when the job has finished.
+func (j *Job) Finished() <-chan struct{} {
+	return j.done
+}
+
+// WaitForeground blocks until the job finishes or is stopped, returning
+// true in the latter case.
+func (j *Job) WaitForeground() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for !j.stopped && !j.finished {
+		j.changed.Wait()
+	}
+	return j.stopped
+}
+
+// Done reports whether the job has finished.
+func (j *Job) Done() bool {
+	select {
+	case <-j.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Table holds the jobs started by a shell. It is safe for concurrent use.
+type Table struct {
+	mu   sync.Mutex
+	jobs []*Job
+	last *Job
+}
+
+func NewTable() *Table {
+	return &Table{}
+}
+
+// Copy returns a table for a subshell. It starts with the jobs of t so
+// they can be listed and waited for, but jobs the subshell starts are
+// not added to t.
+func (t *Table) Copy() *Table {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return &Table{
+		jobs: append([]*Job{}, t.jobs...),
+		last: t.last,
+	}
+}
+
+// New adds a job to the table. It becomes the job reported by '$!'.
+func (t *Table) New(command string) *Job {
+	j := NewJob(command)
+	t.Add(j)
+
+	t.mu.Lock()
+	t.last = j
+	t.mu.Unlock()
+	return j
+}
+
+// Add gives j the lowest job number not in use and adds it to the table.
+func (t *Table) Add(j *Job) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	used := map[int]bool{}
+	for _, x := range t.jobs {
+		used[x.ID] = true
+	}
+	j.ID = 1
+	for used[j.ID] {
+		j.ID++
+	}
+	t.jobs = append(t.jobs, j)
+}
+
+// Last returns the most recently started job or nil if there are none.
+func (t *Table) Last() *Job {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.last
+}
+
+// All returns the jobs in the order they were started.
+func (t *Table) All() []*Job {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]*Job{}, t.jobs...)
+}
+
+// ByPid returns the job with the given pid or nil if there is none.
+func (t *Table) ByPid(pid int) *Job {
+	for _, j := range t.All() {
+		j.mu.Lock()
+		jobPid := j.pid
+		j.mu.Unlock()
+		if jobPid == pid {
+			return j
+		}
+	}
+	return nil
+}
+
+// byPriority returns the jobs ordered so that the first is the current
+// job, '%+', and the second the previous job, '%-'. Stopped jobs come
+// before running ones and newer jobs before older.
+func (t *Table) byPriority() []*Job {
+	// Job numbers are reused so the order they were started in is used
+	// rather than their numbers.
+	all := t.All()
+	for i, j := 0, len(all)-1; i < j; i, j = i+1, j-1 {
+		all[i], all[j] = all[j], all[i]
+	}
+	sort.SliceStable(all, func(a, b int) bool {
+		return all[a].Stopped() && !all[b].Stopped()
+	})
+	return all
+}
+
+// Current returns the job used when a job spec is omitted or nil if
+// there are no jobs.
+func (t *Table) Current() *Job {
+	if all := t.byPriority(); len(all) > 0 {
+		return all[0]
+	}
+	return nil
+}
+
+// Find returns the job matching spec. Specs are
+//
+//	%%, %+, % or ""  the current job
+//	%-               the previous job
+//	%n               job number n
+//	%string          the job whose command starts with string
+//	%?string         the job whose command contains string
+//	n                the job with pid n
+func (t *Table) Find(spec string) (*Job, error) {
+	if spec == "" || spec == "%" || spec == "%%" || spec == "%+" {
+		if j := t.Current(); j != nil {
+			return j, nil
+		}
+		return nil, ErrNoSuchJob
+	}
+	if spec == "%-" {
+		if all := t.byPriority(); len(all) > 1 {
+			return all[1], nil
+		}
+		return nil, ErrNoSuchJob
+	}
+
+	if spec[0] != '%' {
+		pid, err := strconv.Atoi(spec)
+		if err != nil {
+			return nil, ErrNoSuchJob
+		}
+		if j := t.ByPid(pid); j != nil {
+			return j, nil
+		}
+		return nil, ErrNoSuchJob
+	}
+
+	spec = spec[1:]
+	if id, err := strconv.Atoi(spec); err == nil {
+		for _, j := range t.All() {
+			if j.ID == id {
+				return j, nil
+			}
+		}
+		return nil, ErrNoSuchJob
+	}
+
+	match := strings.HasPrefix
+	if strings.HasPrefix(spec, "?") {
+		spec = spec[1:]
+		match = strings.Contains
+	}
+	var found *Job
+	for _, j := range t.All() {
+		if match(j.Command, spec) {
+			if found != nil {
+				return nil, ErrAmbiguousJob
+			}
+			found = j
+		}
+	}
+	if found == nil {
+		return nil, ErrNoSuchJob
+	}
+	return found, nil
+}
+
+// Format describes a job in the form used by the jobs builtin E.g
+//
+//	[1]+  Stopped                 sleep 10
+//
+// If long is set the pid of the job is included.
+func (t *Table) Format(j *Job, long bool) string {
+	marker := ' '
+	all := t.byPriority()
+	if len(all) > 0 && all[0] == j {
+		marker = '+'
+	} else if len(all) > 1 && all[1] == j {
+		marker = '-'
+	}
+
+	state := "Running"
+	if j.Done() {
+		state = "Done"
+		if ex := j.Wait(); ex != T.ExitSuccess {
+			state = fmt.Sprintf("Exit %d", ex)
+		}
+	} else if j.Stopped() {
+		state = "Stopped"
+	}
+
+	if long {
+		return fmt.Sprintf("[%d]%c %d %-24s%s", j.ID, marker, j.Pid(), state, j.Command)
+	}
+	return fmt.Sprintf("[%d]%c  %-24s%s", j.ID, marker, state, j.Command)
+}
+
+// Remove deletes a job from the table once its status has been collected.
+func (t *Table) Remove(j *Job) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for i, x := range t.jobs {
+		if x == j {
+			t.jobs = append(t.jobs[:i], t.jobs[i+1:]...)
+			break
+		}
+	}
+}
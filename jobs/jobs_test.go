@@ -0,0 +1,115 @@
+package jobs
+
+import (
+	"testing"
+
+	"github.com/danwakefield/gosh/T"
+)
+
+func TestJobPid(t *testing.T) {
+	tbl := NewTable()
+
+	j := tbl.New("sleep 10")
+	j.SetPid(1234)
+	j.SetPid(5678)
+	if j.Pid() != 1234 {
+		t.Errorf("First pid should be kept: got %d", j.Pid())
+	}
+
+	j2 := tbl.New("true")
+	j2.Finish(T.ExitFailure)
+	if j2.Pid() != SyntheticPidBase+j2.ID {
+		t.Errorf("Job without a process should get a synthetic pid: got %d", j2.Pid())
+	}
+	if tbl.Last() != j2 {
+		t.Errorf("Last should return the newest job")
+	}
+}
+
+func TestTableByPid(t *testing.T) {
+	tbl := NewTable()
+	j := tbl.New("true")
+	if tbl.ByPid(SyntheticPidBase+j.ID) != nil {
+		t.Errorf("A job should not be found before it has a pid")
+	}
+
+	j.Finish(T.ExitStatus(3))
+	if tbl.ByPid(j.Pid()) != j {
+		t.Fatalf("Job not found by pid")
+	}
+	if !j.Done() || j.Wait() != 3 {
+		t.Errorf("Expected finished job with status 3")
+	}
+
+	tbl.Remove(j)
+	if tbl.ByPid(j.Pid()) != nil || len(tbl.All()) != 0 {
+		t.Errorf("Job not removed from table")
+	}
+}
+
+func TestTableFind(t *testing.T) {
+	tbl := NewTable()
+	sleep := tbl.New("sleep 10")
+	sleep.SetPid(100)
+	cat := tbl.New("cat file")
+	cat.SetPid(200)
+
+	cases := []struct {
+		spec string
+		want *Job
+	}{
+		{"%+", cat},
+		{"%%", cat},
+		{"", cat},
+		{"%", cat},
+		{"%-", sleep},
+		{"%1", sleep},
+		{"%2", cat},
+		{"%sle", sleep},
+		{"%?file", cat},
+		{"100", sleep},
+	}
+	for _, c := range cases {
+		got, err := tbl.Find(c.spec)
+		if err != nil || got != c.want {
+			t.Errorf("Find(%q): got %v %v", c.spec, got, err)
+		}
+	}
+
+	// A stopped job becomes the current job
+	sleep.SetStopped(true)
+	if got, _ := tbl.Find("%+"); got != sleep {
+		t.Errorf("Stopped job should be current")
+	}
+
+	for _, spec := range []string{"%3", "%nothing", "300"} {
+		if _, err := tbl.Find(spec); err != ErrNoSuchJob {
+			t.Errorf("Find(%q): expected ErrNoSuchJob got %v", spec, err)
+		}
+	}
+	tbl.New("sleep 5")
+	if _, err := tbl.Find("%sleep"); err != ErrAmbiguousJob {
+		t.Errorf("Expected ErrAmbiguousJob got %v", err)
+	}
+}
+
+func TestJobNumbersReused(t *testing.T) {
+	tbl := NewTable()
+	first := tbl.New("sleep 1")
+	second := tbl.New("sleep 2")
+	tbl.New("sleep 3")
+
+	tbl.Remove(second)
+	reused := tbl.New("sleep 4")
+	if reused.ID != 2 {
+		t.Errorf("The lowest free job number should be used, got %d", reused.ID)
+	}
+	if tbl.Current() != reused {
+		t.Errorf("The newest job should be current even with a lower number")
+	}
+
+	tbl.Remove(first)
+	if j := tbl.New("sleep 5"); j.ID != 1 {
+		t.Errorf("Job 1 should be reused, got %d", j.ID)
+	}
+}
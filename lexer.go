@@ -3,7 +3,7 @@ package main
 import (
 	"bytes"
 	"errors"
-	"os"
+	"strings"
 	"unicode/utf8"
 
 	"github.com/danwakefield/gosh/char"
@@ -18,6 +18,8 @@ const (
 
 var (
 	ErrQuotedString = errors.New("Unterminated quoted string")
+	ErrBackQuote    = errors.New("Unterminated backquote")
+	ErrSubshell     = errors.New("Unterminated command substitution")
 )
 
 type LexItem struct {
@@ -36,8 +38,8 @@ type Lexer struct {
 	buffer       bytes.Buffer
 	subs         []Substitution
 	quoted       bool
-	backslash    bool
 	backupWidth  int
+	hereDocs     []*HereDoc
 	input        string
 	log          *kisslog.Logger
 
@@ -118,6 +120,7 @@ func (l *Lexer) nextLexItem() LexItem {
 func (l *Lexer) nextChar() rune {
 	if l.position >= l.inputLength {
 		l.position++
+		l.backupWidth = 1
 		return EOFRune
 	}
 	var (
@@ -183,10 +186,13 @@ func (l *Lexer) Start() Token {
 				continue
 			}
 			l.quoted = true
-			l.backslash = true
+			l.Escape()
 			return l.Word()
 		case '\n':
 			l.lineNo++
+			if len(l.hereDocs) > 0 {
+				l.readHereDocs()
+			}
 			return TNewLine
 		case '&':
 			if l.hasNext('&') {
@@ -210,6 +216,9 @@ func (l *Lexer) Start() Token {
 			return TLeftParen
 		case ')':
 			return TRightParen
+		case '<', '>':
+			l.backup()
+			return l.Redirection()
 		}
 	}
 
@@ -221,27 +230,25 @@ OuterLoop:
 	for {
 		c := l.nextChar()
 
-		if l.backslash {
-			if c == EOFRune {
-				l.backup()
-				l.buffer.WriteRune('\\')
-				break
-			}
-			if c == '\\' {
-				l.buffer.WriteRune('\\')
-				continue
-			}
-			l.buffer.WriteRune(SentinalEscape)
-			l.buffer.WriteRune(c)
-			l.backslash = false
-			continue
-		}
-
 		switch c {
-		case '\n', '\t', ' ', '<', '>', '(', ')', ';', '&', '|', EOFRune:
+		case '<', '>':
+			l.backup()
+			if l.isFdNumber() {
+				return l.Redirection()
+			}
+			break OuterLoop
+		case '\n', '\t', ' ', '(', ')', ';', '&', '|', EOFRune:
 			// Characters that cause a word break
 			l.backup()
 			break OuterLoop
+		case '\\':
+			if l.hasNext('\n') {
+				// Line continuation
+				l.lineNo++
+				continue
+			}
+			l.quoted = true
+			l.Escape()
 		case '\'':
 			l.quoted = true
 			l.SingleQuote()
@@ -249,7 +256,7 @@ OuterLoop:
 			l.quoted = true
 			l.DoubleQuote()
 		case '`':
-			l.BackQuote()
+			l.BackQuote(false)
 		case '$':
 			l.Substitution()
 		default:
@@ -260,6 +267,158 @@ OuterLoop:
 	return TWord
 }
 
+// Escape writes the character following a backslash as a quoted character.
+// Upon entering we have read the '\\'.
+func (l *Lexer) Escape() {
+	c := l.nextChar()
+	if c == EOFRune {
+		// A trailing backslash is kept as is
+		l.backup()
+		l.buffer.WriteRune('\\')
+		return
+	}
+	l.writeQuoted(c)
+}
+
+// writeQuoted writes c to the buffer preceded by SentinalEscape. This marks
+// it as quoted so it will not be subject to field splitting or treated as
+// part of a pattern during expansion.
+func (l *Lexer) writeQuoted(c rune) {
+	l.buffer.WriteRune(SentinalEscape)
+	l.buffer.WriteRune(c)
+}
+
+// isFdNumber reports whether the word read so far is an unquoted number
+// that should be treated as the file descriptor of a following redirection.
+// E.g the '2' in '2>&1'
+func (l *Lexer) isFdNumber() bool {
+	if l.buffer.Len() == 0 || l.quoted || len(l.subs) != 0 {
+		return false
+	}
+	for _, c := range l.buffer.String() {
+		if !char.IsDigit(c) {
+			return false
+		}
+	}
+	return true
+}
+
+// Redirection lexes a redirection operator into the buffer. Any file
+// descriptor number preceding the operator is already in the buffer so
+// the LexItem value will be the complete operator E.g '2>>'.
+// The target of the redirection is lexed as a separate word.
+func (l *Lexer) Redirection() Token {
+	c := l.nextChar()
+	l.buffer.WriteRune(c)
+
+	switch c {
+	case '<':
+		switch {
+		case l.hasNext('<'):
+			l.buffer.WriteRune('<')
+			if l.hasNext('<') {
+				l.buffer.WriteRune('<')
+			} else if l.hasNext('-') {
+				l.buffer.WriteRune('-')
+			}
+		case l.hasNext('&'):
+			l.buffer.WriteRune('&')
+		case l.hasNext('>'):
+			l.buffer.WriteRune('>')
+		}
+	case '>':
+		switch {
+		case l.hasNext('>'):
+			l.buffer.WriteRune('>')
+		case l.hasNext('&'):
+			l.buffer.WriteRune('&')
+		case l.hasNext('|'):
+			l.buffer.WriteRune('|')
+		}
+	}
+
+	return TRedirection
+}
+
+// AddHereDoc queues a here-document whose body will be read from the lines
+// following the next newline.
+func (l *Lexer) AddHereDoc(hd *HereDoc) {
+	l.hereDocs = append(l.hereDocs, hd)
+}
+
+// readHereDocs reads the bodies of all queued here-documents. Upon entering
+// we have just read the newline ending the line containing the '<<'
+// redirections.
+func (l *Lexer) readHereDocs() {
+	for _, hd := range l.hereDocs {
+		bodyBuf := bytes.Buffer{}
+
+		for l.position < l.inputLength {
+			var line string
+			lineEnd := strings.IndexByte(l.input[l.position:], '\n')
+			if lineEnd == -1 {
+				line = l.input[l.position:]
+				l.position = l.inputLength
+			} else {
+				line = l.input[l.position : l.position+lineEnd]
+				l.position += lineEnd + 1
+			}
+			l.lineNo++
+
+			if hd.StripTabs {
+				line = strings.TrimLeft(line, "\t")
+			}
+			if line == hd.Delimiter {
+				break
+			}
+			bodyBuf.WriteString(line)
+			bodyBuf.WriteRune('\n')
+		}
+
+		// Quoting any part of the delimiter disables expansions in the
+		// body. We still mark the body as Quoted as the results of any
+		// expansions are not subject to further processing.
+		if hd.Quoted {
+			hd.Body = Arg{Raw: bodyBuf.String(), Quoted: true}
+			continue
+		}
+		hl := NewLexer(bodyBuf.String())
+		hl.HereDocBody()
+		hd.Body = Arg{Raw: hl.buffer.String(), Subs: hl.subs, Quoted: true}
+	}
+	l.hereDocs = nil
+}
+
+// HereDocBody lexes the body of an unquoted here-document. It is treated
+// like the inside of double quotes except that '"' is not special.
+func (l *Lexer) HereDocBody() {
+	for {
+		c := l.nextChar()
+
+		switch c {
+		case EOFRune:
+			return
+		case '$':
+			l.Substitution()
+		case '`':
+			l.BackQuote(false)
+		case '\\':
+			c = l.nextChar()
+			switch c {
+			case '\n':
+				// Ignore an escaped literal newline
+			case '\\', '$', '`':
+				l.buffer.WriteRune(c)
+			default:
+				l.backup()
+				l.buffer.WriteRune('\\')
+			}
+		default:
+			l.buffer.WriteRune(c)
+		}
+	}
+}
+
 func (l *Lexer) DoubleQuote() {
 	// We have consumed the first quote before entering this state.
 	for {
@@ -269,7 +428,13 @@ func (l *Lexer) DoubleQuote() {
 		case EOFRune:
 			panic(ErrQuotedString) //TODO: Dont make this panic
 		case '$':
+			// Marking the substitution as quoted prevents its result
+			// being split or globbed.
+			l.buffer.WriteRune(SentinalEscape)
 			l.Substitution()
+		case '`':
+			l.buffer.WriteRune(SentinalEscape)
+			l.BackQuote(true)
 		case '"':
 			return
 		case '\\':
@@ -278,13 +443,13 @@ func (l *Lexer) DoubleQuote() {
 			case '\n':
 				// Ignore an escaped literal newline
 			case '\\', '$', '`', '"':
-				l.buffer.WriteRune(c)
+				l.writeQuoted(c)
 			default:
 				l.backup()
-				l.buffer.WriteRune('\\')
+				l.writeQuoted('\\')
 			}
 		default:
-			l.buffer.WriteRune(c)
+			l.writeQuoted(c)
 		}
 	}
 }
@@ -300,7 +465,7 @@ func (l *Lexer) SingleQuote() {
 		case '\'':
 			return
 		default:
-			l.buffer.WriteRune(c)
+			l.writeQuoted(c)
 		}
 	}
 }
@@ -343,15 +508,9 @@ func (l *Lexer) VariableSimple() {
 	case char.IsSpecial(c):
 		varbuf.WriteRune(c)
 	case char.IsDigit(c):
-		// Positional argv
-		for {
-			varbuf.WriteRune(c)
-			c = l.nextChar()
-			if !char.IsDigit(c) {
-				l.backup()
-				break
-			}
-		}
+		// Positional argv, only a single digit without braces so '$10'
+		// is '$1' followed by '0'
+		varbuf.WriteRune(c)
 	case char.IsFirstInVarName(c):
 		for {
 			varbuf.WriteRune(c)
@@ -427,8 +586,7 @@ func (l *Lexer) VariableComplex() {
 
 	// Length operator should have returned since only ${#varname} is valid
 	if sv.SubType == VarSubLength {
-		l.log.Error("Line %d: Bad substitution (%s)", l.lineNo, l.input[l.lastPosition:l.position])
-		os.Exit(1)
+		panic(SyntaxError{LineNo: l.lineNo, Msg: "Bad substitution (" + l.input[l.lastPosition:l.position] + ")"})
 	}
 
 	if l.hasNext(':') {
@@ -457,8 +615,7 @@ func (l *Lexer) VariableComplex() {
 			sv.SubType = VarSubTrimRight
 		}
 	default:
-		l.log.Error("Line %d: Bad substitution (%s)", l.lineNo, l.input[l.lastPosition:l.position])
-		os.Exit(1)
+		panic(SyntaxError{LineNo: l.lineNo, Msg: "Bad substitution (" + l.input[l.lastPosition:l.position] + ")"})
 	}
 
 	// Read until '}'
@@ -477,9 +634,50 @@ func (l *Lexer) VariableComplex() {
 	sv.SubVal = subValBuf.String()
 }
 
-func (l *Lexer) BackQuote() {
-	l.log.Error("lexBackQuote - Not Implemented")
-	os.Exit(2)
+// BackQuote lexes the old style of command substitution '`cmd`'.
+// Upon entering we have read the opening '`'.
+// Inside backquotes a backslash only escapes '$', '`', '\\' and a newline
+// and, when the backquotes are themselves inside double quotes, '"'.
+// The unescaped text is then parsed as a separate command list so nested
+// substitutions written as '\\`' work.
+func (l *Lexer) BackQuote(inDoubleQuote bool) {
+	cmdBuf := bytes.Buffer{}
+
+OuterLoop:
+	for {
+		c := l.nextChar()
+
+		switch c {
+		case EOFRune:
+			panic(ErrBackQuote) //TODO: Dont make this panic
+		case '`':
+			break OuterLoop
+		case '\\':
+			c = l.nextChar()
+			switch {
+			case c == '\n':
+				// Line continuation
+				l.lineNo++
+			case c == '$', c == '`', c == '\\', c == '"' && inDoubleQuote:
+				cmdBuf.WriteRune(c)
+			default:
+				l.backup()
+				cmdBuf.WriteRune('\\')
+			}
+		case '\n':
+			l.lineNo++
+			cmdBuf.WriteRune(c)
+		default:
+			cmdBuf.WriteRune(c)
+		}
+	}
+
+	p := NewParser(cmdBuf.String())
+	ss := SubSubshell{}
+	ss.N = p.list(AllowEmptyNode)
+
+	l.buffer.WriteRune(SentinalSubstitution)
+	l.subs = append(l.subs, ss)
 }
 
 func (l *Lexer) Subshell() {
@@ -489,11 +687,14 @@ func (l *Lexer) Subshell() {
 	ss := SubSubshell{}
 	ss.N = p.list(AllowEmptyNode)
 
-	// We have to explictitly get the next item to prevent race conditions
-	// in accessing the lexers Pos and LineNo fields.
-	x := p.lexer.nextLexItem()
-	l.position += x.Pos
-	l.lineNo += x.LineNo
+	// The list ends at the closing ')' which the parser has pushed back.
+	// Its position tells us how much of the input the substitution used.
+	closing := p.next()
+	if closing.Tok != TRightParen {
+		panic(ErrSubshell) //TODO: Dont make this panic
+	}
+	l.position += closing.Pos + 1
+	l.lineNo += closing.LineNo - 1
 
 	l.buffer.WriteRune(SentinalSubstitution)
 	l.subs = append(l.subs, ss)
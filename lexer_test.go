@@ -61,10 +61,9 @@ func TestLex(t *testing.T) {
 	}
 
 	for _, c := range cases {
-		p := &Parser{}
-		l := NewLexer(c.in, p)
+		l := NewLexer(c.in)
 		for count, expectedLexItem := range c.out {
-			got := l.NextLexItem()
+			got := l.nextLexItem()
 			if reflect.DeepEqual(got, expectedLexItem) {
 				t.Errorf(
 					"Lexing:\n %s\nExpected:\n %#v\nas LexItem %d but got:\n %#v\n",
@@ -74,3 +73,37 @@ func TestLex(t *testing.T) {
 		}
 	}
 }
+
+func TestLexRedirections(t *testing.T) {
+	type lexed struct {
+		Tok Token
+		Val string
+	}
+	cases := []struct {
+		in  string
+		out []lexed
+	}{
+		{"foo >bar", []lexed{{TWord, "foo"}, {TRedirection, ">"}, {TWord, "bar"}}},
+		{"foo>>bar", []lexed{{TWord, "foo"}, {TRedirection, ">>"}, {TWord, "bar"}}},
+		{"foo 2>&1", []lexed{{TWord, "foo"}, {TRedirection, "2>&"}, {TWord, "1"}}},
+		{"foo 10<&-", []lexed{{TWord, "foo"}, {TRedirection, "10<&"}, {TWord, "-"}}},
+		{"foo <>bar", []lexed{{TWord, "foo"}, {TRedirection, "<>"}, {TWord, "bar"}}},
+		{"foo >|bar", []lexed{{TWord, "foo"}, {TRedirection, ">|"}, {TWord, "bar"}}},
+		{"foo <<EOF", []lexed{{TWord, "foo"}, {TRedirection, "<<"}, {TWord, "EOF"}}},
+		{"foo <<-EOF", []lexed{{TWord, "foo"}, {TRedirection, "<<-"}, {TWord, "EOF"}}},
+		{"foo <<<bar", []lexed{{TWord, "foo"}, {TRedirection, "<<<"}, {TWord, "bar"}}},
+		{"foo 1a>bar", []lexed{{TWord, "foo"}, {TWord, "1a"}, {TRedirection, ">"}, {TWord, "bar"}}},
+		{"foo '2'>bar", []lexed{{TWord, "foo"}, {TWord, string(SentinalEscape) + "2"}, {TRedirection, ">"}, {TWord, "bar"}}},
+	}
+
+	for _, c := range cases {
+		l := NewLexer(c.in)
+		for count, want := range c.out {
+			li := l.nextLexItem()
+			got := lexed{li.Tok, li.Val}
+			if got != want {
+				t.Errorf("Lexing '%s' item %d: expected %v got %v", c.in, count, want, got)
+			}
+		}
+	}
+}
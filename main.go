@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strconv"
 
 	"gopkg.in/logex.v1"
 
@@ -27,21 +29,90 @@ func main() {
 	}
 	p := NewParser(string(fileContents))
 	scp := variables.NewScope()
-	scp.SetPositionalArgs(os.Args[1:])
-	ex := T.ExitSuccess
+	scp.ImportEnviron(os.Environ())
+	setShellVariables(scp)
+	scp.SetScriptName(os.Args[1])
+	scp.SetPositionalArgs(os.Args[2:])
 
 	stdIO := &T.IOContainer{In: os.Stdin, Out: os.Stdout, Err: os.Stderr}
+	os.Exit(int(run(p, scp, stdIO)))
+}
+
+// setShellVariables sets the variables the shell provides at startup once
+// the environment has been imported.
+func setShellVariables(scp *variables.Scope) {
+	// IFS is never inherited as it changes how the script is split
+	scp.Set("IFS", DefaultIFS)
+
+	// A PWD from the environment is kept if it names the working directory
+	// so that any symbolic links it goes through are preserved.
+	pwd := scp.Get("PWD")
+	if pwd.Set && filepath.IsAbs(pwd.Val) && sameFile(pwd.Val, scp.Pwd) {
+		scp.Pwd = filepath.Clean(pwd.Val)
+	}
+	scp.Set("PWD", scp.Pwd)
+	scp.Export("PWD")
+
+	scp.Set("PPID", strconv.Itoa(os.Getppid()))
+	scp.Set("OPTIND", "1")
+
+	prompts := map[string]string{"PS1": "$ ", "PS2": "> ", "PS4": "+ "}
+	if os.Geteuid() == 0 {
+		prompts["PS1"] = "# "
+	}
+	for k, v := range prompts {
+		if !scp.Get(k).Set {
+			scp.Set(k, v)
+		}
+	}
+
+	level, err := strconv.Atoi(scp.Get("SHLVL").Val)
+	if err != nil || level < 0 {
+		level = 0
+	}
+	scp.Set("SHLVL", strconv.Itoa(level+1))
+	scp.Export("SHLVL")
+}
+
+func sameFile(a, b string) bool {
+	aInfo, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	bInfo, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(aInfo, bInfo)
+}
+
+// run evaluates the commands read by p until the end of the input or
+// until exit is called. It returns the status the shell should exit with
+// rather than exiting itself.
+func run(p *Parser, scp *variables.Scope, ioc *T.IOContainer) T.ExitStatus {
+	ex := T.ExitSuccess
 
 	for {
-		n := p.Parse()
+		n, err := p.Parse()
+		if err != nil {
+			fmt.Fprintf(ioc.Err, "gosh: %s\n", err.Error())
+			return finishShell(scp, ioc, T.ExitUsage)
+		}
 		if n == nil {
 			//Newline
 			continue
 		}
 		if _, isEOF := n.(NodeEOF); isEOF {
-			os.Exit(int(ex))
+			return finishShell(scp, ioc, ex)
 		}
 
-		ex = n.Eval(scp, stdIO)
+		// With 'set -n' commands are read but not executed
+		if !scp.Option(variables.OptionNoExec) {
+			ex = n.Eval(scp, ioc)
+			scp.SetExitStatus(ex)
+		}
+		if scp.ControlPending() {
+			return finishShell(scp, ioc, ex)
+		}
 	}
 }
@@ -2,100 +2,25 @@ package main
 
 import (
 	"bytes"
+	"fmt"
 	"io"
+	"os"
 	"os/exec"
-	"os/user"
+	"path/filepath"
+	"sort"
 	"strings"
-
-	"gopkg.in/logex.v1"
+	"sync"
+	"syscall"
 
 	"github.com/danwakefield/fnmatch"
 	"github.com/danwakefield/gosh/T"
 	"github.com/danwakefield/gosh/builtins"
+	"github.com/danwakefield/gosh/char"
+	"github.com/danwakefield/gosh/jobs"
+	"github.com/danwakefield/gosh/signals"
 	"github.com/danwakefield/gosh/variables"
 )
 
-type Arg struct {
-	Raw    string
-	Quoted bool
-	Subs   []Substitution
-}
-
-type ExpandFlag int
-
-const (
-	NoExpandSubstitutions ExpandFlag = iota
-	NoExpandTilde
-	NoExpandWordSplit
-	NoExpandGlob
-)
-
-func (a Arg) Expand(scp *variables.Scope, flags ...ExpandFlag) (returnString string) {
-	flagSet := func(e ExpandFlag) bool {
-		for _, v := range flags {
-			if v == e {
-				return true
-			}
-		}
-		return false
-	}
-
-	logex.Debugf("Expand '%s'", a.Raw)
-	defer func() {
-		logex.Debugf("Returned '%s'", returnString)
-	}()
-
-	expString := a.Raw
-
-	if !flagSet(NoExpandTilde) {
-		expString = a.expandTilde(scp, expString)
-	}
-
-	if !flagSet(NoExpandSubstitutions) {
-		expString = a.expandSubstitutions(scp, expString)
-	}
-
-	if !flagSet(NoExpandGlob) {
-	}
-
-	// XXX: Do FNmatch pathname expansion here.
-	// see `man 7 glob` for details. Key point is that is the expansion
-	// has no files it should be returned as is.
-	return expString
-}
-
-func (a Arg) expandSubstitutions(scp *variables.Scope, s string) string {
-	if !strings.ContainsRune(s, SentinalSubstitution) {
-		return s
-	}
-
-	buf := bytes.Buffer{}
-	subCounter := 0
-
-	for _, r := range a.Raw {
-		if r == SentinalSubstitution {
-			buf.WriteString(a.Subs[subCounter].Sub(scp))
-			subCounter++
-			continue
-		}
-		buf.WriteRune(r)
-	}
-
-	return buf.String()
-}
-
-func (a Arg) expandTilde(scp *variables.Scope, s string) string {
-	if strings.HasPrefix(s, "~") && !a.Quoted {
-		u, err := user.Current()
-		if err != nil {
-			return s
-		}
-		return u.HomeDir + s[1:]
-	} else {
-		return s
-	}
-}
-
 type Node interface {
 	Eval(*variables.Scope, *T.IOContainer) T.ExitStatus
 }
@@ -112,12 +37,18 @@ type NodeEOF struct {
 type NodeList []Node
 
 // Eval calls Eval on the Nodes contained in the list and returns the
-// T.ExitStatus of the last command.
+// T.ExitStatus of the last command. Evaluation stops early when break,
+// continue, return or exit is pending.
 func (n NodeList) Eval(scp *variables.Scope, ioc *T.IOContainer) T.ExitStatus {
 	returnExit := T.ExitSuccess
 
 	for _, x := range n {
 		returnExit = x.Eval(scp, ioc)
+		scp.SetExitStatus(returnExit)
+		runPendingTraps(scp, ioc)
+		if scp.ControlPending() {
+			break
+		}
 	}
 
 	return returnExit
@@ -132,7 +63,13 @@ type NodeBinary struct {
 func (n NodeBinary) Eval(scp *variables.Scope, ioc *T.IOContainer) T.ExitStatus {
 	var runRight bool
 
+	scp.BeginCondition()
 	leftExit := n.Left.Eval(scp, ioc)
+	scp.EndCondition()
+	scp.SetExitStatus(leftExit)
+	if scp.ControlPending() {
+		return leftExit
+	}
 	if n.IsAnd {
 		runRight = leftExit == T.ExitSuccess
 	} else { // OR
@@ -152,7 +89,9 @@ type NodeNegate struct {
 }
 
 func (n NodeNegate) Eval(scp *variables.Scope, ioc *T.IOContainer) T.ExitStatus {
+	scp.BeginCondition()
 	ex := n.N.Eval(scp, ioc)
+	scp.EndCondition()
 	// Any Non-zero T.ExitStatus is a failure so we only check for success
 	if ex == T.ExitSuccess {
 		return T.ExitFailure
@@ -170,8 +109,17 @@ func (n NodeLoop) Eval(scp *variables.Scope, ioc *T.IOContainer) T.ExitStatus {
 	var runBody bool
 	returnExit := T.ExitSuccess
 
+	scp.EnterLoop()
+	defer scp.LeaveLoop()
+
 	for {
+		scp.BeginCondition()
 		condExit := n.Condition.Eval(scp, ioc)
+		scp.EndCondition()
+		if loopControl(scp) == loopBreak {
+			break
+		}
+
 		if n.IsWhile {
 			runBody = condExit == T.ExitSuccess
 		} else { // Until
@@ -180,6 +128,9 @@ func (n NodeLoop) Eval(scp *variables.Scope, ioc *T.IOContainer) T.ExitStatus {
 
 		if runBody {
 			returnExit = n.Body.Eval(scp, ioc)
+			if loopControl(scp) == loopBreak {
+				break
+			}
 		} else {
 			break
 		}
@@ -188,6 +139,35 @@ func (n NodeLoop) Eval(scp *variables.Scope, ioc *T.IOContainer) T.ExitStatus {
 	return returnExit
 }
 
+type loopAction int
+
+const (
+	loopNext loopAction = iota
+	loopBreak
+)
+
+// loopControl handles break and continue for a loop after its condition
+// or body has been evaluated. Controls for outer loops, or returns, are
+// left pending and cause the loop to stop.
+func loopControl(scp *variables.Scope) loopAction {
+	c, count := scp.Control()
+	switch c {
+	case variables.ControlNone:
+		return loopNext
+	case variables.ControlBreak, variables.ControlContinue:
+		if count > 1 {
+			scp.SetControl(c, count-1)
+			return loopBreak
+		}
+		scp.ClearControl()
+		if c == variables.ControlBreak {
+			return loopBreak
+		}
+		return loopNext
+	}
+	return loopBreak
+}
+
 type NodeFor struct {
 	LoopVar string
 	Args    []Arg
@@ -197,16 +177,27 @@ type NodeFor struct {
 func (n NodeFor) Eval(scp *variables.Scope, ioc *T.IOContainer) T.ExitStatus {
 	returnExit := T.ExitSuccess
 
-	expandedArgs := make([]string, len(n.Args))
-	for i, arg := range n.Args {
-		// This will need to be changed when IFS splitting is coded.
-		// Append each split as a seperate item
-		expandedArgs[i] = arg.Expand(scp)
+	expandedArgs := []string{}
+	for _, arg := range n.Args {
+		expandedArgs = append(expandedArgs, arg.Expand(scp, ioc)...)
 	}
+	// An expansion error such as an unset variable with 'set -u'
+	if scp.ControlPending() {
+		return T.ExitFailure
+	}
+
+	scp.EnterLoop()
+	defer scp.LeaveLoop()
 
 	for _, arg := range expandedArgs {
-		scp.Set(n.LoopVar, arg)
+		if err := scp.Set(n.LoopVar, arg); err != nil {
+			fmt.Fprintf(ioc.Err, "gosh: %s: %s\n", n.LoopVar, err.Error())
+			return T.ExitFailure
+		}
 		returnExit = n.Body.Eval(scp, ioc)
+		if loopControl(scp) == loopBreak {
+			break
+		}
 	}
 
 	return returnExit
@@ -223,7 +214,12 @@ type NodeIf struct {
 }
 
 func (n NodeIf) Eval(scp *variables.Scope, ioc *T.IOContainer) T.ExitStatus {
+	scp.BeginCondition()
 	runBody := n.Condition.Eval(scp, ioc)
+	scp.EndCondition()
+	if scp.ControlPending() {
+		return runBody
+	}
 	if runBody == T.ExitSuccess {
 		return n.Body.Eval(scp, ioc)
 	}
@@ -238,52 +234,309 @@ func (n NodeIf) Eval(scp *variables.Scope, ioc *T.IOContainer) T.ExitStatus {
 type NodeCommand struct {
 	Assign map[string]Arg
 	Args   []Arg
+	Redirs []Redirection
 	LineNo int
+	// Text is the command as written, used to describe it as a job.
+	Text string
 }
 
 func (n NodeCommand) execExternal(scp *variables.Scope, ioc *T.IOContainer, args []string) T.ExitStatus {
-	// This is needed so that pipes will terminate
-	if pw, isPipeWriter := ioc.Out.(*io.PipeWriter); isPipeWriter {
-		defer func() {
-			if err := pw.Close(); err != nil {
-				panic(err) // XXX: Print error to stdout and continue?
+	path, err := findCommand(scp, args[0])
+	if err != nil {
+		return n.exitStatusFromError(ioc, args[0], err)
+	}
+	cmd := &exec.Cmd{Path: path, Args: args}
+	cmd.Env = scp.Environ()
+	cmd.Dir = scp.Pwd
+	// A closed descriptor is left as nil so the child sees /dev/null
+	// rather than a stream that always errors.
+	if !isClosedFd(ioc.In) {
+		cmd.Stdin = ioc.In
+	}
+	if !isClosedFd(ioc.Out) {
+		cmd.Stdout = ioc.Out
+	}
+	if !isClosedFd(ioc.Err) {
+		cmd.Stderr = ioc.Err
+	}
+	cmd.ExtraFiles = execExtraFiles(ioc)
+
+	monitor := scp.Option(variables.OptionMonitor)
+	job := scp.CurrentJob
+	if monitor {
+		if job == nil {
+			// A command outside of a pipeline is a job of its own
+			job = jobs.NewJob(n.Text)
+			job.Foreground = true
+		}
+	}
+
+	err = job.StartProcess(func(pgid int) (int, error) {
+		if monitor {
+			cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true, Pgid: pgid}
+		}
+		if err := scp.WithProcessAttributes(cmd.Start); err != nil {
+			return 0, err
+		}
+		if err := scp.LimitProcess(cmd.Process.Pid); err != nil {
+			cmd.Process.Kill()
+			cmd.Wait()
+			return 0, err
+		}
+		return cmd.Process.Pid, nil
+	}, monitor)
+	if err != nil {
+		return n.exitStatusFromError(ioc, args[0], err)
+	}
+
+	// Signals the shell catches for traps also go to foreground commands
+	if job == nil || job.Foreground {
+		stopForwarding := signals.Forward(cmd.Process, monitor)
+		defer stopForwarding()
+	}
+
+	if monitor {
+		if job.Foreground {
+			jobs.SetForeground(job.Pgid())
+		}
+		if stopped, sig := n.waitStopped(cmd, job, ioc); stopped {
+			jobs.RestoreForeground()
+			if job != scp.CurrentJob {
+				reportStopped(scp, ioc, job)
 			}
-		}()
+			return T.ExitStatus(128 + int(sig))
+		}
+		if job.Foreground {
+			jobs.RestoreForeground()
+		}
 	}
 
-	cmd := exec.Command(args[0], args[1:]...)
-	cmd.Env = scp.Environ()
-	cmd.Stdin = ioc.In
-	cmd.Stderr = ioc.Err
-	cmd.Stdout = ioc.Out
+	ex := n.wait(cmd, ioc)
+	job.ProcessExited(ex)
+	return ex
+}
 
-	err := cmd.Run()
+// waitStopped waits for a command started with job control to exit or,
+// if it is in the foreground, to be stopped. A stopped foreground command
+// is left for a goroutine to collect once it has been continued so that
+// the shell can carry on.
+func (n NodeCommand) waitStopped(cmd *exec.Cmd, job *jobs.Job, ioc *T.IOContainer) (bool, syscall.Signal) {
+	for {
+		stopped, sig := jobs.WaitStopped(cmd.Process.Pid)
+		if !stopped {
+			return false, 0
+		}
+		job.SetStopped(true)
+		if !job.Foreground {
+			continue
+		}
+
+		go func() {
+			for {
+				if stopped, _ := jobs.WaitStopped(cmd.Process.Pid); !stopped {
+					break
+				}
+				job.SetStopped(true)
+			}
+			job.ProcessExited(n.wait(cmd, ioc))
+		}()
+		return true, sig
+	}
+}
+
+func (n NodeCommand) wait(cmd *exec.Cmd, ioc *T.IOContainer) T.ExitStatus {
+	err := cmd.Wait()
 	if err == nil {
 		return T.ExitSuccess
 	}
+	return n.exitStatusFromError(ioc, cmd.Args[0], err)
+}
+
+// reportStopped adds a foreground job that has been stopped to the job
+// table so it can be continued with fg or bg.
+func reportStopped(scp *variables.Scope, ioc *T.IOContainer, job *jobs.Job) {
+	scp.Jobs.Add(job)
+	job.Detach()
+	fmt.Fprintf(ioc.Err, "\n%s\n", scp.Jobs.Format(job, false))
+}
+
+// exitStatusFromError converts the error returned from running an external
+// command into the exit status the shell should report. Errors starting
+// the command are written to ioc.Err.
+// findCommand returns the path of the command name. A name without a slash
+// is searched for in the $PATH of the Scope rather than that of the process.
+func findCommand(scp *variables.Scope, name string) (string, error) {
+	if strings.ContainsRune(name, '/') {
+		return scp.AbsPath(name), nil
+	}
+
+	pathVar := scp.Get("PATH")
+	dirs := pathVar.Val
+	if !pathVar.Set {
+		dirs = os.Getenv("PATH")
+	}
+	for _, dir := range filepath.SplitList(dirs) {
+		// An empty entry is the current directory
+		path := scp.AbsPath(filepath.Join(dir, name))
+		if isExecutableFile(path) {
+			return path, nil
+		}
+	}
+	return "", &exec.Error{Name: name, Err: exec.ErrNotFound}
+}
+
+func isExecutableFile(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && fi.Mode().IsRegular() && fi.Mode()&0111 != 0
+}
+
+func (n NodeCommand) exitStatusFromError(ioc *T.IOContainer, name string, err error) T.ExitStatus {
+	switch e := err.(type) {
+	case *exec.ExitError:
+		ws, ok := e.Sys().(syscall.WaitStatus)
+		if !ok {
+			return T.ExitFailure
+		}
+		if ws.Signaled() {
+			// A command killed by a signal reports 128 + the signal number
+			return T.ExitStatus(128 + int(ws.Signal()))
+		}
+		return T.ExitStatus(ws.ExitStatus())
+	case *exec.Error:
+		// The command could not be found in $PATH
+		if e.Err == exec.ErrNotFound {
+			fmt.Fprintf(ioc.Err, "gosh: %d: %s: not found\n", n.LineNo, name)
+			return T.ExitUnknownCommand
+		}
+		err = e.Err
+	case *os.PathError:
+		err = e.Err
+	}
+
+	switch {
+	case os.IsNotExist(err):
+		fmt.Fprintf(ioc.Err, "gosh: %d: %s: not found\n", n.LineNo, name)
+		return T.ExitUnknownCommand
+	case os.IsPermission(err), err == syscall.ENOEXEC, err == syscall.EISDIR:
+		fmt.Fprintf(ioc.Err, "gosh: %d: %s: Permission denied\n", n.LineNo, name)
+		return T.ExitNotExecutable
+	}
+
+	fmt.Fprintf(ioc.Err, "gosh: %d: %s: %s\n", n.LineNo, name, err.Error())
 	return T.ExitFailure
 }
 
+func isClosedFd(f interface{}) bool {
+	_, closed := f.(T.ClosedFd)
+	return closed
+}
+
+// execExtraFiles returns the descriptors above 2 that can be inherited by a
+// child process. Only real files can be passed on, anything else is left
+// closed in the child.
+func execExtraFiles(ioc *T.IOContainer) []*os.File {
+	maxFd := 2
+	for fd := range ioc.Extra {
+		if fd > maxFd {
+			maxFd = fd
+		}
+	}
+	if maxFd == 2 {
+		return nil
+	}
+
+	files := make([]*os.File, maxFd-2)
+	for fd, f := range ioc.Extra {
+		if osFile, isFile := f.(*os.File); isFile {
+			files[fd-3] = osFile
+		}
+	}
+	return files
+}
+
+func (n NodeCommand) hasCommandSubstitution() bool {
+	args := n.Args
+	for _, v := range n.Assign {
+		args = append(args, v)
+	}
+	for _, a := range args {
+		for _, sub := range a.Subs {
+			if _, isSubshell := sub.(SubSubshell); isSubshell {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 func (n NodeCommand) execFunction(scp *variables.Scope, ioc *T.IOContainer, args []string) T.ExitStatus {
 	return T.ExitSuccess
 }
 
 func (n NodeCommand) Eval(scp *variables.Scope, ioc *T.IOContainer) T.ExitStatus {
-	// A line with only assignments applies them to the Root Scope
-	// We check this first to avoid unnecessary scope Push/Pop's
-	if len(n.Args) == 0 {
-		for k, v := range n.Assign {
-			scp.Set(k, v.Expand(scp))
-		}
-		return T.ExitSuccess
-	}
+	ex := n.eval(scp, ioc)
+	checkErrExit(scp, ex)
+	return ex
+}
+
+func (n NodeCommand) eval(scp *variables.Scope, ioc *T.IOContainer) T.ExitStatus {
+	// A background job that runs no external command before its first
+	// command finishes is given a synthetic pid.
+	defer scp.CurrentJob.SetPid(0)
 
 	// Minimum of len(n.Args) after expansions, Likely
 	// that it will be more after globbing though
 	expandedArgs := []string{}
 	for _, arg := range n.Args {
-		expandedArgs = append(expandedArgs, arg.Expand(scp))
+		expandedArgs = append(expandedArgs, arg.Expand(scp, ioc)...)
+	}
+	assigns := map[string]string{}
+	for k, v := range n.Assign {
+		assigns[k] = v.ExpandString(scp, ioc)
 	}
+	// An expansion error exits the shell before the command runs
+	if scp.ControlPending() {
+		return T.ExitFailure
+	}
+
+	if scp.Option(variables.OptionXTrace) {
+		n.trace(scp, ioc, assigns, expandedArgs)
+	}
+
+	// A line with only assignments applies them to the Root Scope
+	// We check this first to avoid unnecessary scope Push/Pop's.
+	// This includes a command name that expands to nothing E.g '$EMPTY'
+	if len(expandedArgs) == 0 {
+		for k, v := range assigns {
+			if err := scp.Set(k, v); err != nil {
+				fmt.Fprintf(ioc.Err, "gosh: %s: %s\n", k, err.Error())
+				return T.ExitFailure
+			}
+		}
+		// Redirections are still performed without a command.
+		// E.g '>file' creates or truncates file
+		_, closers, err := applyRedirections(scp, ioc, n.Redirs)
+		if err != nil {
+			fmt.Fprintf(ioc.Err, "gosh: %s\n", err.Error())
+			return T.ExitFailure
+		}
+		closeAll(closers)
+
+		// Without a command the status is that of the last command
+		// substitution which has been recorded in the scope.
+		if n.hasCommandSubstitution() {
+			return scp.ExitStatus()
+		}
+		return T.ExitSuccess
+	}
+
+	redirIOC, closers, err := applyRedirections(scp, ioc, n.Redirs)
+	if err != nil {
+		fmt.Fprintf(ioc.Err, "gosh: %s\n", err.Error())
+		return T.ExitFailure
+	}
+	defer closeAll(closers)
+	ioc = redirIOC
 
 	// Order of precedence:
 	// Relative command > Builtin > User Function > Other external command
@@ -295,13 +548,37 @@ func (n NodeCommand) Eval(scp *variables.Scope, ioc *T.IOContainer) T.ExitStatus
 		scp.Push()
 		defer scp.Pop()
 
-		for k, v := range n.Assign {
-			scp.Set(k, v.Expand(scp), variables.LocalScope)
+		// Assignments before a command are exported to it only
+		for k, v := range assigns {
+			if err := scp.Set(k, v, variables.LocalScope); err != nil {
+				fmt.Fprintf(ioc.Err, "gosh: %s: %s\n", k, err.Error())
+				return T.ExitFailure
+			}
+			scp.Export(k)
 		}
 		return n.execExternal(scp, ioc, expandedArgs)
 	}
 
 	if builtinFound {
+		if builtins.Special[command] {
+			for k, v := range assigns {
+				if err := scp.Set(k, v); err != nil {
+					fmt.Fprintf(ioc.Err, "gosh: %s: %s\n", k, err.Error())
+					return T.ExitFailure
+				}
+			}
+		} else if len(assigns) > 0 {
+			// E.g 'IFS= read line' only changes IFS for read
+			scp.Push()
+			defer scp.Pop()
+			for k, v := range assigns {
+				if err := scp.Set(k, v, variables.LocalScope); err != nil {
+					fmt.Fprintf(ioc.Err, "gosh: %s: %s\n", k, err.Error())
+					return T.ExitFailure
+				}
+				scp.Export(k)
+			}
+		}
 		return builtinFunc(scp, ioc, expandedArgs[1:])
 	}
 
@@ -313,6 +590,44 @@ func (n NodeCommand) Eval(scp *variables.Scope, ioc *T.IOContainer) T.ExitStatus
 	return T.ExitUnknownCommand
 }
 
+// trace writes the expanded command to ioc.Err prefixed by $PS4 for
+// 'set -x'. Words are quoted where needed so they can be read back.
+func (n NodeCommand) trace(scp *variables.Scope, ioc *T.IOContainer, assigns map[string]string, args []string) {
+	names := []string{}
+	for k := range assigns {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	words := []string{}
+	for _, k := range names {
+		words = append(words, k+"="+char.QuoteIfNeeded(assigns[k]))
+	}
+	for _, a := range args {
+		words = append(words, char.QuoteIfNeeded(a))
+	}
+	if len(words) == 0 {
+		return
+	}
+
+	prefix := "+ "
+	if ps4 := scp.Get("PS4"); ps4.Set {
+		prefix = ps4.Val
+	}
+	fmt.Fprintf(ioc.Err, "%s%s\n", prefix, strings.Join(words, " "))
+}
+
+// checkErrExit exits the shell when a command fails with 'set -e' unless
+// the status of the command is being tested.
+func checkErrExit(scp *variables.Scope, ex T.ExitStatus) {
+	if scp.ControlPending() {
+		return
+	}
+	if ex != T.ExitSuccess && scp.Option(variables.OptionErrExit) && !scp.InCondition() {
+		ExitShell(scp, ex)
+	}
+}
+
 type NodeCaseList struct {
 	Patterns []Arg
 	Body     Node
@@ -322,9 +637,9 @@ func (n NodeCaseList) Eval(scp *variables.Scope, ioc *T.IOContainer) T.ExitStatu
 	return n.Body.Eval(scp, ioc)
 }
 
-func (n NodeCaseList) Matches(s string, scp *variables.Scope) bool {
+func (n NodeCaseList) Matches(s string, scp *variables.Scope, ioc *T.IOContainer) bool {
 	for _, p := range n.Patterns {
-		expandedPat := p.Expand(scp)
+		expandedPat := p.ExpandPattern(scp, ioc)
 		if fnmatch.Match(expandedPat, s, 0) {
 			return true
 		}
@@ -338,10 +653,13 @@ type NodeCase struct {
 }
 
 func (n NodeCase) Eval(scp *variables.Scope, ioc *T.IOContainer) T.ExitStatus {
-	expandedExpr := n.Expr.Expand(scp)
+	expandedExpr := n.Expr.ExpandString(scp, ioc)
+	if scp.ControlPending() {
+		return T.ExitFailure
+	}
 
 	for _, c := range n.Cases {
-		if c.Matches(expandedExpr, scp) {
+		if c.Matches(expandedExpr, scp, ioc) {
 			return c.Eval(scp, ioc)
 		}
 	}
@@ -349,33 +667,126 @@ func (n NodeCase) Eval(scp *variables.Scope, ioc *T.IOContainer) T.ExitStatus {
 }
 
 type NodePipe struct {
-	Background bool
-	Commands   NodeList
+	Commands NodeList
+	// Text is the pipeline as written, used to describe it as a job.
+	Text string
 }
 
 func (n NodePipe) Eval(scp *variables.Scope, ioc *T.IOContainer) T.ExitStatus {
-	lastPipeReader, pipeWriter := io.Pipe()
+	var wg sync.WaitGroup
+	lastIn := ioc.In
+
+	// With job control the external commands of a pipeline share a process
+	// group so they can be stopped and continued together. Commands that
+	// run inside the shell cannot be stopped and are waited for as usual.
+	job := scp.CurrentJob
+	if scp.Option(variables.OptionMonitor) && job == nil {
+		job = jobs.NewJob(n.Text)
+		job.Foreground = true
+	}
+	stageScope := func() *variables.Scope {
+		s := scp.Copy()
+		s.CurrentJob = job
+		return s
+	}
+	// Statuses of each command for pipefail
+	exits := make([]T.ExitStatus, len(n.Commands))
+
+	// Each command runs in its own copy of the scope as they are evaluated
+	// concurrently. Real pipes are used so external commands can read and
+	// write them directly and see EOF / SIGPIPE when the other end closes.
+	for i, cmd := range n.Commands[:len(n.Commands)-1] {
+		pipeReader, pipeWriter, err := os.Pipe()
+		if err != nil {
+			fmt.Fprintf(ioc.Err, "gosh: %s\n", err.Error())
+			return T.ExitFailure
+		}
 
-	scp = scp.Copy()
+		wg.Add(1)
+		go func(i int, cmd Node, in io.Reader) {
+			defer wg.Done()
+			stageScp := stageScope()
+			stageIOC := &T.IOContainer{In: in, Out: pipeWriter, Err: ioc.Err, Extra: ioc.Extra}
+			exits[i] = finishShell(stageScp, stageIOC, cmd.Eval(stageScp, stageIOC))
+			pipeWriter.Close()
+			if pr, isPipe := in.(*os.File); isPipe && in != ioc.In {
+				pr.Close()
+			}
+		}(i, cmd, lastIn)
 
-	cmd := n.Commands[0]
-	go cmd.Eval(scp, &T.IOContainer{In: &bytes.Buffer{}, Out: pipeWriter, Err: ioc.Err})
+		lastIn = pipeReader
+	}
 
-	for _, cmd = range n.Commands[1 : len(n.Commands)-1] {
-		pipeReader, pipeWriter := io.Pipe()
-		go cmd.Eval(scp, &T.IOContainer{In: lastPipeReader, Out: pipeWriter, Err: ioc.Err})
-		lastPipeReader = pipeReader
+	cmd := n.Commands[len(n.Commands)-1]
+	lastScp := stageScope()
+	lastIOC := &T.IOContainer{In: lastIn, Out: ioc.Out, Err: ioc.Err, Extra: ioc.Extra}
+	ex := finishShell(lastScp, lastIOC, cmd.Eval(lastScp, lastIOC))
+	exits[len(exits)-1] = ex
+	// Closing the read end lets earlier commands that are still
+	// writing terminate.
+	lastIn.(*os.File).Close()
+	wg.Wait()
+
+	if job != scp.CurrentJob && job.Stopped() {
+		reportStopped(scp, ioc, job)
 	}
 
-	cmd = n.Commands[len(n.Commands)-1]
-	if !n.Background {
-		return cmd.Eval(scp, &T.IOContainer{In: lastPipeReader, Out: ioc.Out, Err: ioc.Err})
+	// With pipefail the status is that of the last command to fail
+	if scp.Option(variables.OptionPipeFail) {
+		for _, stageExit := range exits[:len(exits)-1] {
+			if stageExit != T.ExitSuccess {
+				ex = stageExit
+			}
+		}
+		if exits[len(exits)-1] != T.ExitSuccess {
+			ex = exits[len(exits)-1]
+		}
 	}
+	checkErrExit(scp, ex)
+	return ex
+}
+
+// NodeBackground runs its Node asynchronously in a copy of the scope and
+// returns immediately. E.g 'sleep 10 &'
+// The job is added to the scope's job table so it can be waited for and
+// its pid is reported by '$!'.
+type NodeBackground struct {
+	N Node
+	// Text is the command as written, used to describe it as a job.
+	Text string
+}
+
+func (n NodeBackground) Eval(scp *variables.Scope, ioc *T.IOContainer) T.ExitStatus {
+	job := scp.Jobs.New(n.Text)
+
+	bgScp := scp.Copy()
+	bgScp.CurrentJob = job
+	// Without job control the input of an asynchronous list is /dev/null
+	bgIOC := ioc.Copy()
+	bgIOC.In = &bytes.Buffer{}
+
+	go func() {
+		ex := n.N.Eval(bgScp, bgIOC)
+		job.Finish(finishShell(bgScp, bgIOC, ex))
+	}()
 
-	go cmd.Eval(scp, &T.IOContainer{In: lastPipeReader, Out: ioc.Out, Err: ioc.Err})
 	return T.ExitSuccess
 }
 
+// NodeSubshell evaluates a list in a copy of the current scope so that
+// assignments, function definitions and changes of directory do not affect
+// the caller. E.g '( cd /tmp; make )'
+type NodeSubshell struct {
+	N Node
+}
+
+func (n NodeSubshell) Eval(scp *variables.Scope, ioc *T.IOContainer) T.ExitStatus {
+	subScp := scp.Copy()
+	ex := finishShell(subScp, ioc, n.N.Eval(subScp, ioc))
+	checkErrExit(scp, ex)
+	return ex
+}
+
 type NodeFunction struct {
 	Body Node
 	Name string
@@ -389,5 +800,12 @@ func (n NodeFunction) Eval(scp *variables.Scope, ioc *T.IOContainer) T.ExitStatu
 func (n NodeFunction) EvalFunc(scp *variables.Scope, ioc *T.IOContainer, args []string) T.ExitStatus {
 	scp.PushFunction(args)
 	defer scp.Pop()
-	return n.Body.Eval(scp, ioc)
+	saved := scp.EnterFunction()
+	defer scp.LeaveFunction(saved)
+
+	ex := n.Body.Eval(scp, ioc)
+	if c, _ := scp.Control(); c == variables.ControlReturn {
+		scp.ClearControl()
+	}
+	return ex
 }
@@ -2,7 +2,7 @@ package main
 
 import (
 	"fmt"
-	"os"
+	"runtime"
 	"strings"
 
 	"github.com/danwakefield/kisslog"
@@ -10,6 +10,16 @@ import (
 	"github.com/danwakefield/gosh/variables"
 )
 
+// SyntaxError is returned by Parse when the input is not a valid command.
+type SyntaxError struct {
+	LineNo int
+	Msg    string
+}
+
+func (e SyntaxError) Error() string {
+	return fmt.Sprintf("Line %d: Syntax error: %s", e.LineNo, e.Msg)
+}
+
 type Parser struct {
 	lexer       *Lexer
 	lastLexItem LexItem
@@ -42,8 +52,12 @@ func (p *Parser) expect(expected ...Token) {
 			return
 		}
 	}
-	p.log.Error("Unexpected Token: %s\nWanted one of %s", got, expected)
-	os.Exit(1)
+	p.fail(got.LineNo, "Unexpected Token: %s, wanted one of %s", got.Tok, expected)
+}
+
+// fail stops parsing. The SyntaxError is returned by Parse.
+func (p *Parser) fail(lineNo int, format string, args ...interface{}) {
+	panic(SyntaxError{LineNo: lineNo, Msg: fmt.Sprintf(format, args...)})
 }
 
 func (p *Parser) backup() {
@@ -65,7 +79,40 @@ func (p *Parser) peekToken() Token {
 	return t.Tok
 }
 
-func (p *Parser) Parse() Node {
+// peekPos returns the position of the next token in the input.
+func (p *Parser) peekPos() int {
+	t := p.next()
+	p.backup()
+	return t.Pos
+}
+
+// text returns the input between two positions, used to describe jobs.
+func (p *Parser) text(start, end int) string {
+	if start < 0 || end > len(p.lexer.input) || start >= end {
+		return ""
+	}
+	return strings.TrimSpace(p.lexer.input[start:end])
+}
+
+// Parse returns the next complete command in the input, nil for an empty
+// line or NodeEOF at the end. Errors found by the parser or the lexer are
+// returned as a SyntaxError.
+func (p *Parser) Parse() (n Node, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			switch t := r.(type) {
+			case SyntaxError:
+				err = t
+			case runtime.Error:
+				panic(r)
+			case error:
+				err = SyntaxError{LineNo: p.lexer.lineNo, Msg: t.Error()}
+			default:
+				panic(r)
+			}
+		}
+	}()
+
 	p.lexer.CheckAlias = true
 	p.lexer.IgnoreNewlines = false
 	p.lexer.CheckKeyword = true
@@ -73,13 +120,13 @@ func (p *Parser) Parse() Node {
 
 	switch tok.Tok {
 	case TEOF:
-		return NodeEOF{}
+		return NodeEOF{}, nil
 	case TNewLine:
 		// Looks like this is done in dash to allow for interactive shell use.
-		return nil
+		return nil, nil
 	default:
 		p.backup()
-		return p.list(ObserveNewlines)
+		return p.list(ObserveNewlines), nil
 	}
 }
 
@@ -101,9 +148,13 @@ func (p *Parser) list(nlf NewlineFlag) Node {
 		return NodeNoop{}
 	}
 	for {
+		start := p.peekPos()
 		n := p.andOr()
 		tok := p.next()
 
+		if tok.Tok == TBackground {
+			n = NodeBackground{N: n, Text: p.text(start, tok.Pos)}
+		}
 		nodes = append(nodes, n)
 
 		switch tok.Tok {
@@ -124,8 +175,7 @@ func (p *Parser) list(nlf NewlineFlag) Node {
 			return nodes
 		default:
 			if nlf == ObserveNewlines {
-				p.log.Error("Unexpected Token: %s: %#v", tok.Tok, tok)
-				os.Exit(1)
+				p.fail(tok.LineNo, "Unexpected Token: %s", tok.Tok)
 			}
 			p.backup()
 			return nodes
@@ -160,6 +210,7 @@ func (p *Parser) andOr() Node {
 
 func (p *Parser) pipeline() Node {
 	negate := false
+	start := p.peekPos()
 
 	if p.hasNextToken(TNot) {
 		negate = true
@@ -183,6 +234,7 @@ func (p *Parser) pipeline() Node {
 				break
 			}
 		}
+		n.Text = p.text(start, p.lastLexItem.Pos)
 		returnNode = n
 	}
 
@@ -198,8 +250,7 @@ func (p *Parser) command() Node {
 
 	switch tok.Tok {
 	default:
-		p.log.Error("command - unexpected token: %s: %#v\n", tok.Tok, tok)
-		os.Exit(1)
+		p.fail(tok.LineNo, "Unexpected Token: %s", tok.Tok)
 	case TIf:
 		returnNode = parseIf(p)
 	case TWhile, TUntil:
@@ -221,20 +272,60 @@ func (p *Parser) command() Node {
 	case TBegin:
 		returnNode = p.list(IgnoreNewlines)
 		p.expect(TEnd)
-	case TWord:
+	case TLeftParen:
+		returnNode = NodeSubshell{N: p.list(IgnoreNewlines)}
+		p.expect(TRightParen)
+	case TWord, TRedirection:
 		p.backup()
-		returnNode = p.simpleCommand()
+		return p.simpleCommand()
+	}
+
+	// Compound commands can be followed by redirections that apply to
+	// the whole command.
+	redirs := []Redirection{}
+	for p.hasNextToken(TRedirection) {
+		redirs = append(redirs, p.redirection())
+	}
+	if len(redirs) > 0 {
+		returnNode = NodeRedirect{N: returnNode, Redirs: redirs}
 	}
 
 	return returnNode
 }
 
+// redirection parses the target of the TRedirection that has just been
+// read and returns the complete Redirection.
+func (p *Parser) redirection() Redirection {
+	op := p.lastLexItem
+	tok := p.next()
+	if tok.Tok != TWord {
+		p.fail(op.LineNo, "Expected a word after redirection '%s'", op.Val)
+	}
+
+	r, err := NewRedirection(op.Val, Arg{Raw: tok.Val, Subs: tok.Subs, Quoted: tok.Quoted})
+	if err != nil {
+		p.fail(op.LineNo, "%s '%s'", err.Error(), op.Val)
+	}
+
+	if r.Type == RedirHereDoc {
+		r.HereDoc = &HereDoc{
+			Delimiter: strings.Replace(tok.Val, string(SentinalEscape), "", -1),
+			StripTabs: strings.HasSuffix(op.Val, "-"),
+			Quoted:    tok.Quoted,
+		}
+		p.lexer.AddHereDoc(r.HereDoc)
+	}
+	return r
+}
+
 // simpleCommand
 func (p *Parser) simpleCommand() Node {
 	tok := p.next()
 	assignments := map[string]Arg{}
 	args := []Arg{}
+	redirs := []Redirection{}
 	startLine := tok.LineNo
+	startPos := tok.Pos
 	assignmentAllowed := true
 
 	p.lexer.CheckAlias = true
@@ -253,12 +344,14 @@ OuterLoop:
 				assignmentAllowed = false
 				args = append(args, Arg{Raw: tok.Val, Subs: tok.Subs, Quoted: tok.Quoted})
 			}
+		case TRedirection:
+			redirs = append(redirs, p.redirection())
 		case TLeftParen:
-			if len(args) == 1 && len(assignments) == 0 {
+			if len(args) == 1 && len(assignments) == 0 && len(redirs) == 0 {
 				p.expect(TRightParen)
 				name := args[0]
 				if !variables.IsGoodName(name.Raw) {
-					panic("Bad function name: " + name.Raw)
+					p.fail(tok.LineNo, "Bad function name: %s", name.Raw)
 				}
 				p.lexer.CheckAlias = true
 				p.lexer.IgnoreNewlines = true
@@ -279,7 +372,9 @@ OuterLoop:
 	n := NodeCommand{}
 	n.Assign = assignments
 	n.Args = args
+	n.Redirs = redirs
 	n.LineNo = startLine
+	n.Text = p.text(startPos, p.lastLexItem.Pos)
 	return n
 }
 
@@ -396,7 +491,7 @@ func parseCase(p *Parser) Node {
 				p.backup()
 				break
 			}
-			ncl.Patterns = append(ncl.Patterns, Arg{Raw: tok.Val, Subs: tok.Subs})
+			ncl.Patterns = append(ncl.Patterns, Arg{Raw: tok.Val, Subs: tok.Subs, Quoted: tok.Quoted})
 			if !p.hasNextToken(TPipe) {
 				break
 			}
@@ -423,8 +518,7 @@ func parseCase(p *Parser) Node {
 		} else if tok.Tok == TEndCase {
 			continue
 		} else {
-			p.log.Error("Expected ';;' or 'esac' on line %d", tok.LineNo)
-			os.Exit(1)
+			p.fail(tok.LineNo, "Expected ';;' or 'esac'")
 		}
 	}
 
@@ -439,10 +533,7 @@ func parseCase(p *Parser) Node {
 func parseFor(p *Parser) Node {
 	tok := p.next()
 	if tok.Tok != TWord || tok.Quoted || !variables.IsGoodName(tok.Val) {
-		p.log.Error(fmt.Sprintf("Bad for loop variable name", kisslog.Attrs{
-			"name": tok.Val,
-			"line": tok.LineNo,
-		}))
+		p.fail(tok.LineNo, "Bad for loop variable name: %s", tok.Val)
 	}
 
 	n := NodeFor{Args: []Arg{}}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/danwakefield/gosh/T"
+	"github.com/danwakefield/gosh/variables"
+)
+
+var (
+	ErrBadRedirection = errors.New("Bad redirection")
+)
+
+type RedirType int
+
+const (
+	RedirInput      RedirType = iota // <
+	RedirOutput                      // >
+	RedirClobber                     // >|
+	RedirAppend                      // >>
+	RedirReadWrite                   // <>
+	RedirDupInput                    // <&
+	RedirDupOutput                   // >&
+	RedirHereDoc                     // << and <<-
+	RedirHereString                  // <<<
+)
+
+var redirLookup = map[string]RedirType{
+	"<":   RedirInput,
+	">":   RedirOutput,
+	">|":  RedirClobber,
+	">>":  RedirAppend,
+	"<>":  RedirReadWrite,
+	"<&":  RedirDupInput,
+	">&":  RedirDupOutput,
+	"<<":  RedirHereDoc,
+	"<<-": RedirHereDoc,
+	"<<<": RedirHereString,
+}
+
+type Redirection struct {
+	Fd      int
+	Type    RedirType
+	Target  Arg
+	HereDoc *HereDoc
+}
+
+// HereDoc holds a here-document. The body is filled in by the lexer once it
+// has read the lines following the redirection so Redirections share a
+// pointer to it.
+type HereDoc struct {
+	Delimiter string
+	StripTabs bool
+	Quoted    bool
+	Body      Arg
+}
+
+// NewRedirection creates a Redirection from an operator lexed as a
+// TRedirection E.g '2>>'. When no file descriptor precedes the operator
+// the default for the operator is used, 0 for input and 1 for output.
+func NewRedirection(op string, target Arg) (Redirection, error) {
+	r := Redirection{Target: target}
+
+	fdEnd := strings.IndexAny(op, "<>")
+	if fdEnd == -1 {
+		return r, ErrBadRedirection
+	}
+
+	t, found := redirLookup[op[fdEnd:]]
+	if !found {
+		return r, ErrBadRedirection
+	}
+	r.Type = t
+
+	if fdEnd == 0 {
+		if op[0] == '<' {
+			r.Fd = 0
+		} else {
+			r.Fd = 1
+		}
+		return r, nil
+	}
+
+	fd, err := strconv.Atoi(op[:fdEnd])
+	if err != nil {
+		return r, ErrBadRedirection
+	}
+	r.Fd = fd
+	return r, nil
+}
+
+// applyRedirections returns a copy of ioc with the redirections applied in
+// order. Any files opened are returned so the caller can close them once
+// the command has finished.
+func applyRedirections(scp *variables.Scope, ioc *T.IOContainer, redirs []Redirection) (*T.IOContainer, []io.Closer, error) {
+	newIOC := ioc.Copy()
+	closers := []io.Closer{}
+
+	for _, r := range redirs {
+		switch r.Type {
+		case RedirHereDoc:
+			newIOC.SetFd(r.Fd, strings.NewReader(r.HereDoc.Body.ExpandString(scp, ioc)))
+			continue
+		case RedirHereString:
+			newIOC.SetFd(r.Fd, strings.NewReader(r.Target.ExpandString(scp, ioc)+"\n"))
+			continue
+		}
+
+		target := r.Target.ExpandString(scp, ioc)
+
+		switch r.Type {
+		case RedirDupInput, RedirDupOutput:
+			if target == "-" {
+				newIOC.SetFd(r.Fd, nil)
+				continue
+			}
+			srcFd, err := strconv.Atoi(target)
+			if err != nil {
+				closeAll(closers)
+				return nil, nil, fmt.Errorf("%s: Illegal file descriptor name", target)
+			}
+			f, open := newIOC.Fd(srcFd)
+			if !open {
+				closeAll(closers)
+				return nil, nil, fmt.Errorf("%d: %s", srcFd, T.ErrBadFd)
+			}
+			newIOC.SetFd(r.Fd, f)
+			continue
+		}
+
+		var flags int
+		switch r.Type {
+		case RedirInput:
+			flags = os.O_RDONLY
+		case RedirOutput, RedirClobber:
+			flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		case RedirAppend:
+			flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+		case RedirReadWrite:
+			flags = os.O_RDWR | os.O_CREATE
+		}
+
+		path := scp.AbsPath(target)
+		if r.Type == RedirOutput && scp.Option(variables.OptionNoClobber) {
+			// With 'set -C' an existing regular file is not truncated
+			// unless the '>|' operator is used.
+			fi, err := os.Stat(path)
+			switch {
+			case err == nil && fi.Mode().IsRegular():
+				closeAll(closers)
+				return nil, nil, fmt.Errorf("%s: cannot overwrite existing file", target)
+			case err == nil:
+				flags = os.O_WRONLY
+			default:
+				flags = os.O_WRONLY | os.O_CREATE | os.O_EXCL
+			}
+		}
+
+		var f *os.File
+		err := scp.WithProcessAttributes(func() (err error) {
+			f, err = os.OpenFile(path, flags, 0666)
+			return err
+		})
+		if err != nil {
+			closeAll(closers)
+			return nil, nil, err
+		}
+		closers = append(closers, f)
+		newIOC.SetFd(r.Fd, f)
+	}
+
+	return newIOC, closers, nil
+}
+
+func closeAll(closers []io.Closer) {
+	for _, c := range closers {
+		c.Close()
+	}
+}
+
+// NodeRedirect applies redirections around a compound command E.g
+//
+//	while read x; do echo $x; done <file
+type NodeRedirect struct {
+	N      Node
+	Redirs []Redirection
+}
+
+func (n NodeRedirect) Eval(scp *variables.Scope, ioc *T.IOContainer) T.ExitStatus {
+	newIOC, closers, err := applyRedirections(scp, ioc, n.Redirs)
+	if err != nil {
+		fmt.Fprintf(ioc.Err, "gosh: %s\n", err.Error())
+		return T.ExitFailure
+	}
+	defer closeAll(closers)
+
+	return n.N.Eval(scp, newIOC)
+}
@@ -0,0 +1,208 @@
+// Package signals catches the signals the shell has traps for. Signals
+// are counted as they arrive so each shell scope can run its handlers
+// between commands, and are passed on to foreground child processes.
+package signals
+
+import (
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// Names maps signal names, without the SIG prefix, to signals.
+var Names = map[string]syscall.Signal{
+	"HUP":    syscall.SIGHUP,
+	"INT":    syscall.SIGINT,
+	"QUIT":   syscall.SIGQUIT,
+	"ILL":    syscall.SIGILL,
+	"TRAP":   syscall.SIGTRAP,
+	"ABRT":   syscall.SIGABRT,
+	"BUS":    syscall.SIGBUS,
+	"FPE":    syscall.SIGFPE,
+	"KILL":   syscall.SIGKILL,
+	"USR1":   syscall.SIGUSR1,
+	"SEGV":   syscall.SIGSEGV,
+	"USR2":   syscall.SIGUSR2,
+	"PIPE":   syscall.SIGPIPE,
+	"ALRM":   syscall.SIGALRM,
+	"TERM":   syscall.SIGTERM,
+	"CHLD":   syscall.SIGCHLD,
+	"CONT":   syscall.SIGCONT,
+	"STOP":   syscall.SIGSTOP,
+	"TSTP":   syscall.SIGTSTP,
+	"TTIN":   syscall.SIGTTIN,
+	"TTOU":   syscall.SIGTTOU,
+	"URG":    syscall.SIGURG,
+	"XCPU":   syscall.SIGXCPU,
+	"XFSZ":   syscall.SIGXFSZ,
+	"VTALRM": syscall.SIGVTALRM,
+	"PROF":   syscall.SIGPROF,
+	"WINCH":  syscall.SIGWINCH,
+	"IO":     syscall.SIGIO,
+	"SYS":    syscall.SIGSYS,
+}
+
+// Parse returns the signal named by s. Names may have a SIG prefix and
+// numbers are accepted.
+func Parse(s string) (syscall.Signal, bool) {
+	if n, err := strconv.Atoi(s); err == nil {
+		for _, sig := range Names {
+			if int(sig) == n {
+				return sig, true
+			}
+		}
+		return 0, false
+	}
+	sig, found := Names[strings.TrimPrefix(strings.ToUpper(s), "SIG")]
+	return sig, found
+}
+
+// Name returns the name of sig without the SIG prefix.
+func Name(sig syscall.Signal) string {
+	for name, s := range Names {
+		if s == sig {
+			return name
+		}
+	}
+	return strconv.Itoa(int(sig))
+}
+
+var (
+	mu         sync.Mutex
+	caught     = make(chan os.Signal, 16)
+	counts     = map[syscall.Signal]int{}
+	arrived    = make(chan struct{})
+	forwardTo  = map[*os.Process]bool{}
+	dispatcher sync.Once
+)
+
+// Disposition is how an owner, such as a shell scope, wants a signal to
+// be handled.
+type Disposition int
+
+const (
+	DefaultAction Disposition = iota
+	IgnoreAction
+	CatchAction
+)
+
+// wanted holds the dispositions requested through Want for each signal.
+var wanted = map[syscall.Signal]map[interface{}]Disposition{}
+
+// Want records the disposition owner wants for sig. As the disposition
+// is shared by the whole process the signal is caught if any owner wants
+// it caught, otherwise ignored if any wants it ignored. An owner cannot
+// restore the default action while another relies on it being changed.
+func Want(owner interface{}, sig syscall.Signal, d Disposition) {
+	mu.Lock()
+	defer mu.Unlock()
+	if wanted[sig] == nil {
+		wanted[sig] = map[interface{}]Disposition{}
+	}
+	if d == DefaultAction {
+		delete(wanted[sig], owner)
+	} else {
+		wanted[sig][owner] = d
+	}
+	apply(sig)
+}
+
+// Release drops the dispositions wanted by owner once it has finished.
+func Release(owner interface{}) {
+	mu.Lock()
+	defer mu.Unlock()
+	for sig, owners := range wanted {
+		if _, found := owners[owner]; found {
+			delete(owners, owner)
+			apply(sig)
+		}
+	}
+}
+
+func apply(sig syscall.Signal) {
+	d := DefaultAction
+	for _, w := range wanted[sig] {
+		if w > d {
+			d = w
+		}
+	}
+	switch d {
+	case CatchAction:
+		Catch(sig)
+	case IgnoreAction:
+		Ignore(sig)
+	default:
+		Default(sig)
+	}
+}
+
+// Catch starts counting arrivals of sig instead of its default action.
+func Catch(sig syscall.Signal) {
+	dispatcher.Do(func() { go dispatch() })
+	signal.Notify(caught, sig)
+}
+
+// Ignore ignores sig. Unlike caught signals it stays ignored in child
+// processes.
+func Ignore(sig syscall.Signal) {
+	signal.Ignore(sig)
+}
+
+// Default restores the default action of sig for the whole process,
+// regardless of the dispositions wanted.
+func Default(sig syscall.Signal) {
+	signal.Reset(sig)
+}
+
+// Count returns the number of times sig has been caught.
+func Count(sig syscall.Signal) int {
+	mu.Lock()
+	defer mu.Unlock()
+	return counts[sig]
+}
+
+// Arrived returns a channel that is closed when the next signal is caught.
+func Arrived() <-chan struct{} {
+	mu.Lock()
+	defer mu.Unlock()
+	return arrived
+}
+
+// Forward passes caught signals on to p until the returned function is
+// called. If p shares the shell's process group it has already been sent
+// the signals generated by the terminal so those are not repeated.
+func Forward(p *os.Process, ownGroup bool) func() {
+	mu.Lock()
+	forwardTo[p] = ownGroup
+	mu.Unlock()
+
+	return func() {
+		mu.Lock()
+		delete(forwardTo, p)
+		mu.Unlock()
+	}
+}
+
+func fromTerminal(sig syscall.Signal) bool {
+	return sig == syscall.SIGINT || sig == syscall.SIGQUIT
+}
+
+func dispatch() {
+	for s := range caught {
+		sig := s.(syscall.Signal)
+
+		mu.Lock()
+		counts[sig]++
+		close(arrived)
+		arrived = make(chan struct{})
+		for p, ownGroup := range forwardTo {
+			if ownGroup || !fromTerminal(sig) {
+				p.Signal(sig)
+			}
+		}
+		mu.Unlock()
+	}
+}
@@ -2,12 +2,14 @@ package main
 
 import (
 	"fmt"
-	"os"
 
 	"github.com/danwakefield/gosh/T"
+	"github.com/danwakefield/gosh/variables"
 )
 
-func ExitShellWithMessage(ex T.ExitStatus, msg string) {
-	fmt.Println(msg)
-	os.Exit(int(ex))
+// ExitShellWithMessage writes msg to ioc.Err then exits the shell, or the
+// enclosing subshell, with ex once evaluation has unwound.
+func ExitShellWithMessage(scp *variables.Scope, ioc *T.IOContainer, ex T.ExitStatus, msg string) {
+	fmt.Fprintf(ioc.Err, "gosh: %s\n", msg)
+	ExitShell(scp, ex)
 }
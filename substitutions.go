@@ -2,7 +2,6 @@ package main
 
 import (
 	"bytes"
-	"os"
 	"strconv"
 	"strings"
 
@@ -14,27 +13,32 @@ import (
 )
 
 type Substitution interface {
-	Sub(*variables.Scope) string
+	Sub(*variables.Scope, *T.IOContainer) string
 }
 
 type SubSubshell struct {
 	N Node
 }
 
-func (s SubSubshell) Sub(scp *variables.Scope) (returnString string) {
+func (s SubSubshell) Sub(scp *variables.Scope, ioc *T.IOContainer) (returnString string) {
 	logex.Debug("Substituting shell")
 	defer func() {
 		logex.Debugf("Returned '%s'", returnString)
 	}()
 
 	if _, isNoop := s.N.(NodeNoop); isNoop {
+		scp.SetExitStatus(T.ExitSuccess)
 		return ""
 	}
 
 	out := &bytes.Buffer{}
-	// Not sure if we need to capture this exit code for the $? var.
-	// Ignore it for now
-	_ = s.N.Eval(scp.Copy(), &T.IOContainer{&bytes.Buffer{}, out, os.Stderr})
+	// Record the exit status for '$?'. A command consisting only of
+	// assignments returns the status of its last substitution.
+	// E.g 'A=$(false)'
+	subScp := scp.Copy()
+	subIOC := &T.IOContainer{In: &bytes.Buffer{}, Out: out, Err: ioc.Err}
+	ex := finishShell(subScp, subIOC, s.N.Eval(subScp, subIOC))
+	scp.SetExitStatus(ex)
 
 	return strings.TrimRight(out.String(), "\n")
 }
@@ -68,20 +72,40 @@ type SubVariable struct {
 	SubType   VarSubType
 }
 
-func (s SubVariable) Sub(scp *variables.Scope) (returnString string) {
+// expandsToFields reports whether the substitution is of the positional
+// parameters as separate fields. This is '$@', quoted or not, and an
+// unquoted '$*'.
+func (s SubVariable) expandsToFields(quoted bool) bool {
+	if s.SubType != VarSubNormal {
+		return false
+	}
+	return s.VarName == "@" || (s.VarName == "*" && !quoted)
+}
+
+func (s SubVariable) Sub(scp *variables.Scope, ioc *T.IOContainer) (returnString string) {
 	logex.Debug("Substituting variable")
 	defer func() {
 		logex.Debugf("Returned '%s'", returnString)
 	}()
 	v := scp.Get(s.VarName)
 
+	// With 'set -u' expanding an unset variable is an error. The
+	// positional parameters as a whole, $@ and $*, are exempt.
+	if !v.Set && scp.Option(variables.OptionNoUnset) && s.VarName != "@" && s.VarName != "*" {
+		if s.SubType == VarSubNormal || s.SubType == VarSubLength {
+			ExitShellWithMessage(scp, ioc, T.ExitFailure, s.VarName+": Parameter not set")
+		}
+	}
+
 	switch s.SubType {
 	case VarSubNormal:
 		return v.Val
 	case VarSubLength:
 		// For the values ${#*} and ${#@}
 		// the number of positional parameters is returned
-		// We need to perform IFS splitting to figure this out
+		if s.VarName == "@" || s.VarName == "*" {
+			return strconv.Itoa(len(scp.PositionalArgs()))
+		}
 		return strconv.Itoa(len(v.Val))
 	}
 
@@ -96,7 +120,10 @@ func (s SubVariable) Sub(scp *variables.Scope) (returnString string) {
 		if varExists {
 			return v.Val
 		}
-		scp.Set(s.VarName, s.SubVal)
+		if err := scp.Set(s.VarName, s.SubVal); err != nil {
+			ExitShellWithMessage(scp, ioc, T.ExitFailure, s.VarName+": "+err.Error())
+			return ""
+		}
 		return s.SubVal
 	case VarSubMinus:
 		if varExists {
@@ -113,11 +140,14 @@ func (s SubVariable) Sub(scp *variables.Scope) (returnString string) {
 			return v.Val
 		}
 		if s.SubVal != "" {
-			ExitShellWithMessage(T.ExitFailure, s.SubVal)
+			ExitShellWithMessage(scp, ioc, T.ExitFailure, s.SubVal)
+		} else {
+			ExitShellWithMessage(scp, ioc, T.ExitFailure, s.VarName+": Parameter not set")
 		}
-		ExitShellWithMessage(T.ExitFailure, s.VarName+": Parameter not set")
+		return ""
 	case VarSubTrimRight, VarSubTrimRightMax, VarSubTrimLeft, VarSubTrimLeftMax:
-		ExitShellWithMessage(T.ExitFailure, "Trim operations not implemented")
+		ExitShellWithMessage(scp, ioc, T.ExitFailure, "Trim operations not implemented")
+		return ""
 	}
 
 	logex.Fatal("SubVariable.Sub unreached")
@@ -128,11 +158,12 @@ type SubArith struct {
 	Raw string
 }
 
-func (s SubArith) Sub(scp *variables.Scope) string {
+func (s SubArith) Sub(scp *variables.Scope, ioc *T.IOContainer) string {
 	logex.Debug("Subtituting arithmetic")
 	i, err := arith.Parse(s.Raw, scp)
 	if err != nil {
-		panic(err)
+		ExitShellWithMessage(scp, ioc, T.ExitFailure, err.Error())
+		return ""
 	}
 	return strconv.FormatInt(i, 10)
 }
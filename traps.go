@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+
+	"github.com/danwakefield/gosh/T"
+	"github.com/danwakefield/gosh/signals"
+	"github.com/danwakefield/gosh/variables"
+)
+
+// evalString parses and evaluates src in the given scope, returning the
+// status of the last command. It stops early if break, continue, return
+// or exit is pending. A syntax error is reported and stops evaluation
+// with ExitUsage.
+func evalString(scp *variables.Scope, ioc *T.IOContainer, src string) T.ExitStatus {
+	p := NewParser(src)
+	ex := T.ExitSuccess
+
+	for {
+		n, err := p.Parse()
+		if err != nil {
+			fmt.Fprintf(ioc.Err, "gosh: %s\n", err.Error())
+			return T.ExitUsage
+		}
+		if n == nil {
+			continue
+		}
+		if _, isEOF := n.(NodeEOF); isEOF {
+			return ex
+		}
+		ex = n.Eval(scp, ioc)
+		scp.SetExitStatus(ex)
+		if scp.ControlPending() {
+			return ex
+		}
+	}
+}
+
+// runPendingTraps runs the trap actions for signals received since the
+// scope last checked. It is called between commands. The value of '$?'
+// is preserved across the actions.
+func runPendingTraps(scp *variables.Scope, ioc *T.IOContainer) {
+	for _, sig := range scp.PendingSignals() {
+		action, set := scp.Trap(signals.Name(sig))
+		if !set {
+			// The signal was only caught so the EXIT trap could run
+			exitOnSignal(scp, ioc, sig)
+		}
+
+		// A trap runs between commands so any pending break or return
+		// is set aside while it runs.
+		ex := scp.ExitStatus()
+		c, count := scp.Control()
+		scp.ClearControl()
+		evalString(scp, ioc, action)
+		// An exit in the action ends the shell with its own status,
+		// otherwise a control it left pending replaces the one set
+		// aside unless that was an exit.
+		if after, _ := scp.Control(); after == variables.ControlExit {
+			return
+		}
+		if c == variables.ControlExit || !scp.ControlPending() {
+			scp.SetControl(c, count)
+		}
+		scp.SetExitStatus(ex)
+	}
+}
+
+// runExitTrap runs the EXIT trap of the scope if one is set. The trap is
+// removed first so it only runs once.
+func runExitTrap(scp *variables.Scope, ioc *T.IOContainer) {
+	action, set := scp.Trap(variables.TrapExit)
+	if !set {
+		return
+	}
+	scp.ResetTrap(variables.TrapExit)
+	if action != "" {
+		evalString(scp, ioc, action)
+	}
+}
+
+// ExitShell makes the shell exit with ex. Evaluation unwinds to the
+// top level, or to the enclosing subshell which is the one to exit.
+func ExitShell(scp *variables.Scope, ex T.ExitStatus) {
+	scp.SetControl(variables.ControlExit, int(ex))
+}
+
+// finishShell is called when the commands of a shell or subshell have
+// finished with ex, either by running out or through exit. It runs the
+// EXIT trap and returns the status the shell exits with, which the trap
+// may change by calling exit itself.
+func finishShell(scp *variables.Scope, ioc *T.IOContainer, ex T.ExitStatus) T.ExitStatus {
+	if c, status := scp.Control(); c == variables.ControlExit {
+		ex = T.ExitStatus(status)
+	}
+	scp.ClearControl()
+	scp.SetExitStatus(ex)
+
+	runExitTrap(scp, ioc)
+	if c, status := scp.Control(); c == variables.ControlExit {
+		ex = T.ExitStatus(status)
+	}
+	scp.ClearControl()
+	scp.ReleaseSignals()
+	return ex
+}
+
+// exitOnSignal runs the EXIT trap then lets sig kill the shell so that
+// its parent sees how it died.
+func exitOnSignal(scp *variables.Scope, ioc *T.IOContainer, sig syscall.Signal) {
+	runExitTrap(scp, ioc)
+	signals.Default(sig)
+	syscall.Kill(os.Getpid(), sig)
+	os.Exit(128 + int(sig))
+}
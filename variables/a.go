@@ -4,7 +4,7 @@ import "github.com/danwakefield/gosh/char"
 
 func IsAssignment(s string) bool {
 	rs := []rune(s)
-	if !char.IsFirstInVarName(rs[0]) {
+	if len(rs) == 0 || !char.IsFirstInVarName(rs[0]) {
 		return false
 	}
 	for _, c := range rs[1:] {
@@ -17,7 +17,7 @@ func IsAssignment(s string) bool {
 
 func IsGoodName(s string) bool {
 	rs := []rune(s)
-	if !char.IsFirstInVarName(rs[0]) {
+	if len(rs) == 0 || !char.IsFirstInVarName(rs[0]) {
 		return false
 	}
 	for _, c := range rs[1:] {
@@ -0,0 +1,87 @@
+package variables
+
+// Control is a pending change to the flow of evaluation, set by builtins
+// such as break, return and exit. Nodes stop evaluating when one is pending
+// and the node it targets, E.g the enclosing loop, clears it. A pending exit
+// is only cleared where the shell or subshell ends.
+type Control int
+
+const (
+	ControlNone Control = iota
+	ControlBreak
+	ControlContinue
+	ControlReturn
+	ControlExit
+)
+
+// SetControl makes c pending. For break and continue n is the number of
+// enclosing loops affected, for exit it is the exit status.
+func (s *Scope) SetControl(c Control, n int) {
+	s.control = c
+	s.controlCount = n
+}
+
+// Control returns the pending control and its count.
+func (s *Scope) Control() (Control, int) {
+	return s.control, s.controlCount
+}
+
+// ClearControl removes the pending control once it has been acted on.
+func (s *Scope) ClearControl() {
+	s.control = ControlNone
+	s.controlCount = 0
+}
+
+// ControlPending reports whether evaluation should stop because of a
+// pending control.
+func (s *Scope) ControlPending() bool {
+	return s.control != ControlNone
+}
+
+// EnterLoop and LeaveLoop track the number of enclosing loops so break
+// and continue know how many they can affect.
+func (s *Scope) EnterLoop() {
+	s.loopDepth++
+}
+
+func (s *Scope) LeaveLoop() {
+	s.loopDepth--
+}
+
+// LoopDepth returns the number of loops enclosing the current command in
+// the current function.
+func (s *Scope) LoopDepth() int {
+	return s.loopDepth
+}
+
+// EnterFunction is called when a function starts. Loops outside of it
+// cannot be affected by break and continue. The returned value must be
+// passed to LeaveFunction.
+func (s *Scope) EnterFunction() int {
+	saved := s.loopDepth
+	s.loopDepth = 0
+	s.callDepth++
+	return saved
+}
+
+// LeaveFunction ends a call started with EnterFunction.
+func (s *Scope) LeaveFunction(savedLoopDepth int) {
+	s.loopDepth = savedLoopDepth
+	s.callDepth--
+}
+
+// EnterSource and LeaveSource surround a sourced file. Unlike a function
+// the loops around it can still be affected by break and continue.
+func (s *Scope) EnterSource() {
+	s.callDepth++
+}
+
+func (s *Scope) LeaveSource() {
+	s.callDepth--
+}
+
+// InFunction reports whether a function or sourced file is running, so
+// return can be used.
+func (s *Scope) InFunction() bool {
+	return s.callDepth > 0
+}
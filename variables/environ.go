@@ -0,0 +1,18 @@
+package variables
+
+import (
+	"strings"
+)
+
+// ImportEnviron sets the variables in env, in the form 'A=1', in the root
+// scope and marks them exported so they are passed on to commands.
+// Entries whose names are empty or not valid variable names are skipped.
+func (s *Scope) ImportEnviron(env []string) {
+	for _, e := range env {
+		parts := strings.SplitN(e, "=", 2)
+		if len(parts) != 2 || !IsGoodName(parts[0]) {
+			continue
+		}
+		s.scopes[0][parts[0]] = Variable{Val: parts[1], Set: true, Exported: true}
+	}
+}
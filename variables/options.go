@@ -0,0 +1,85 @@
+package variables
+
+import "sort"
+
+// ShellOption is an option that changes the behaviour of the shell. They
+// are changed using the set builtin. Options that have a single letter
+// form, E.g 'set -f', use that letter as their value. Those that can only
+// be set by name, E.g 'set -o pipefail', use values outside ASCII.
+type ShellOption rune
+
+const (
+	OptionErrExit   ShellOption = 'e'
+	OptionNoGlob    ShellOption = 'f'
+	OptionMonitor   ShellOption = 'm'
+	OptionNoExec    ShellOption = 'n'
+	OptionNoUnset   ShellOption = 'u'
+	OptionXTrace    ShellOption = 'x'
+	OptionNoClobber ShellOption = 'C'
+	OptionPipeFail  ShellOption = 0x100
+)
+
+// OptionNames maps the names used with 'set -o name' to options.
+var OptionNames = map[string]ShellOption{
+	"errexit":   OptionErrExit,
+	"noglob":    OptionNoGlob,
+	"monitor":   OptionMonitor,
+	"noexec":    OptionNoExec,
+	"nounset":   OptionNoUnset,
+	"xtrace":    OptionXTrace,
+	"noclobber": OptionNoClobber,
+	"pipefail":  OptionPipeFail,
+}
+
+// IsOptionLetter reports whether r is the single letter form of an option.
+func IsOptionLetter(r rune) bool {
+	if r > 0x7f {
+		return false
+	}
+	for _, o := range OptionNames {
+		if rune(o) == r {
+			return true
+		}
+	}
+	return false
+}
+
+// SetOption turns a shell option on or off.
+func (s *Scope) SetOption(o ShellOption, on bool) {
+	s.options[o] = on
+}
+
+// Option reports whether a shell option is turned on.
+func (s *Scope) Option(o ShellOption) bool {
+	return s.options[o]
+}
+
+// OptionLetters returns the letters of the options that are on, sorted,
+// as reported by '$-'.
+func (s *Scope) OptionLetters() string {
+	letters := []rune{}
+	for o, on := range s.options {
+		if on && IsOptionLetter(rune(o)) {
+			letters = append(letters, rune(o))
+		}
+	}
+	sort.Slice(letters, func(i, j int) bool { return letters[i] < letters[j] })
+	return string(letters)
+}
+
+// BeginCondition marks the start of a command whose exit status is
+// tested, E.g the condition of an if or the left side of '&&'. Commands
+// that fail do not cause an exit with errexit until EndCondition.
+func (s *Scope) BeginCondition() {
+	s.conditions++
+}
+
+// EndCondition ends a command started with BeginCondition.
+func (s *Scope) EndCondition() {
+	s.conditions--
+}
+
+// InCondition reports whether a command whose status is tested is running.
+func (s *Scope) InCondition() bool {
+	return s.conditions > 0
+}
@@ -0,0 +1,94 @@
+package variables
+
+import (
+	"os"
+	"sync"
+	"syscall"
+)
+
+// processAttrs serializes changes to the umask and resource limits of the
+// process, which are shared by every Scope.
+var processAttrs sync.Mutex
+
+// processUmask returns the umask the process was started with.
+func processUmask() int {
+	processAttrs.Lock()
+	defer processAttrs.Unlock()
+	mask := syscall.Umask(0)
+	syscall.Umask(mask)
+	return mask
+}
+
+// Limit returns the resource limit for resource, as set by ulimit in the
+// Scope or otherwise that of the process.
+func (s *Scope) Limit(resource int) (syscall.Rlimit, error) {
+	if lim, found := s.Limits[resource]; found {
+		return lim, nil
+	}
+	var lim syscall.Rlimit
+	err := syscall.Getrlimit(resource, &lim)
+	return lim, err
+}
+
+// SetLimit sets the resource limit for commands started by the Scope. A
+// limit that setrlimit(2) would refuse is reported here, without changing
+// the limits of the process, rather than when a command is started.
+func (s *Scope) SetLimit(resource int, lim syscall.Rlimit) error {
+	old, err := s.Limit(resource)
+	if err != nil {
+		return err
+	}
+	switch {
+	case lim.Cur > lim.Max:
+		return syscall.EINVAL
+	case lim.Max > old.Max && os.Geteuid() != 0:
+		// Only a privileged process can raise a hard limit
+		return syscall.EPERM
+	case resource == syscall.RLIMIT_NOFILE && lim.Max > maxOpenFiles():
+		return syscall.EPERM
+	}
+	s.Limits[resource] = lim
+	return nil
+}
+
+// WithProcessAttributes calls f with the umask and soft resource limits of
+// the Scope applied to the process so that files and commands created by
+// f get them. The previous values are restored afterwards. A hard limit
+// is never lowered for the process as it could not be raised again,
+// LimitProcess applies it to a command once it has started.
+func (s *Scope) WithProcessAttributes(f func() error) error {
+	processAttrs.Lock()
+	defer processAttrs.Unlock()
+
+	oldMask := syscall.Umask(s.Umask)
+	defer syscall.Umask(oldMask)
+
+	for resource, lim := range s.Limits {
+		var old syscall.Rlimit
+		if err := syscall.Getrlimit(resource, &old); err != nil {
+			return err
+		}
+		applied := lim
+		if applied.Max < old.Max {
+			applied.Max = old.Max
+		}
+		if err := syscall.Setrlimit(resource, &applied); err != nil {
+			return err
+		}
+		defer syscall.Setrlimit(resource, &old)
+	}
+	return f()
+}
+
+// LimitProcess applies the resource limits of the Scope to pid, a command
+// started within WithProcessAttributes, so that it gets the hard limits
+// too. A command that has already exited is ignored.
+func (s *Scope) LimitProcess(pid int) error {
+	for resource, lim := range s.Limits {
+		err := prlimit(pid, resource, &lim)
+		if err != nil && err != syscall.ESRCH {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+//go:build linux
+// +build linux
+
+package variables
+
+import (
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// prlimit sets the resource limit of the process pid.
+func prlimit(pid, resource int, lim *syscall.Rlimit) error {
+	_, _, errno := syscall.RawSyscall6(syscall.SYS_PRLIMIT64, uintptr(pid), uintptr(resource), uintptr(unsafe.Pointer(lim)), 0, 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// maxOpenFiles returns the highest limit on open files the kernel allows.
+func maxOpenFiles() uint64 {
+	b, err := ioutil.ReadFile("/proc/sys/fs/nr_open")
+	if err != nil {
+		return ^uint64(0)
+	}
+	n, err := strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64)
+	if err != nil {
+		return ^uint64(0)
+	}
+	return n
+}
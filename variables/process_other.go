@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package variables
+
+import "syscall"
+
+// Without prlimit(2) commands get the soft limits applied while they are
+// started but keep the hard limits of the shell.
+
+func prlimit(pid, resource int, lim *syscall.Rlimit) error { return nil }
+
+func maxOpenFiles() uint64 { return ^uint64(0) }
@@ -0,0 +1,132 @@
+package variables
+
+import (
+	"sort"
+	"syscall"
+
+	"github.com/danwakefield/gosh/signals"
+)
+
+// TrapExit is the pseudo signal whose trap runs when the shell exits.
+const TrapExit = "EXIT"
+
+// exitSignals are caught while an EXIT trap is set so that the trap still
+// runs when the shell is killed by one of them.
+var exitSignals = []syscall.Signal{syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM}
+
+// SetTrap sets the action run when the named signal is received, or for
+// TrapExit when the shell exits. An empty action ignores the signal.
+// Names are those of signals.Names.
+func (s *Scope) SetTrap(name, action string) {
+	s.traps[name] = action
+	s.updateSignals(name)
+}
+
+// ResetTrap removes the trap for the named signal restoring its default
+// action.
+func (s *Scope) ResetTrap(name string) {
+	delete(s.traps, name)
+	s.updateSignals(name)
+}
+
+// Trap returns the action for the named signal. The second value is false
+// if no trap is set.
+func (s *Scope) Trap(name string) (string, bool) {
+	action, set := s.traps[name]
+	return action, set
+}
+
+// TrapNames returns the names of the signals with traps set, sorted.
+func (s *Scope) TrapNames() []string {
+	names := []string{}
+	for name := range s.traps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (s *Scope) updateSignals(name string) {
+	if name == TrapExit {
+		for _, sig := range exitSignals {
+			s.updateSignal(sig)
+		}
+		return
+	}
+	if sig, found := signals.Names[name]; found {
+		s.updateSignal(sig)
+	}
+}
+
+func (s *Scope) updateSignal(sig syscall.Signal) {
+	// Signals received before the trap was set do not run it.
+	s.signalsSeen[sig] = signals.Count(sig)
+
+	action, set := s.traps[signals.Name(sig)]
+	_, exitTrap := s.traps[TrapExit]
+	switch {
+	case set && action == "":
+		signals.Want(s, sig, signals.IgnoreAction)
+	case set, exitTrap && isExitSignal(sig):
+		signals.Want(s, sig, signals.CatchAction)
+	default:
+		signals.Want(s, sig, signals.DefaultAction)
+	}
+}
+
+// ReleaseSignals gives up the signal dispositions the Scope's traps
+// needed. It is called once a shell or subshell has finished so its traps
+// no longer affect the process.
+func (s *Scope) ReleaseSignals() {
+	signals.Release(s)
+}
+
+func isExitSignal(sig syscall.Signal) bool {
+	for _, s := range exitSignals {
+		if s == sig {
+			return true
+		}
+	}
+	return false
+}
+
+// PendingSignals returns the signals received since the last call that
+// this scope has traps for, or that should run its EXIT trap.
+func (s *Scope) PendingSignals() []syscall.Signal {
+	pending := []syscall.Signal{}
+	for sig := range s.caughtSignals() {
+		n := signals.Count(sig)
+		if n > s.signalsSeen[sig] {
+			s.signalsSeen[sig] = n
+			pending = append(pending, sig)
+		}
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i] < pending[j] })
+	return pending
+}
+
+// HasPendingSignal reports whether PendingSignals would return anything
+// without marking the signals as seen.
+func (s *Scope) HasPendingSignal() (syscall.Signal, bool) {
+	for sig := range s.caughtSignals() {
+		if signals.Count(sig) > s.signalsSeen[sig] {
+			return sig, true
+		}
+	}
+	return 0, false
+}
+
+func (s *Scope) caughtSignals() map[syscall.Signal]bool {
+	caught := map[syscall.Signal]bool{}
+	for name, action := range s.traps {
+		if sig, found := signals.Names[name]; found && action != "" {
+			caught[sig] = true
+		}
+	}
+	if _, exitTrap := s.traps[TrapExit]; exitTrap {
+		for _, sig := range exitSignals {
+			caught[sig] = true
+		}
+	}
+	return caught
+}
@@ -1,17 +1,28 @@
 package variables
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"syscall"
+
+	"github.com/danwakefield/gosh/T"
+	"github.com/danwakefield/gosh/jobs"
+)
+
+var (
+	ErrReadOnly = errors.New("is read only")
 )
 
 type Variable struct {
 	Val      string
 	Set      bool
 	ReadOnly bool
+	// Exported variables are passed in the environment of commands.
+	Exported bool
 }
 
 type ScopeOption int
@@ -23,40 +34,121 @@ type VarScope map[string]Variable
 type Scope struct {
 	scopes       []VarScope
 	currentScope int
+	// params holds the positional parameters for each VarScope
+	params       [][]string
+	scriptName   string
 	Functions    map[string]interface{}
 	Pwd          string
 	OldPwd       string
+	options      map[ShellOption]bool
+	conditions   int
+	exitStatus   T.ExitStatus
+	control      Control
+	controlCount int
+	loopDepth    int
+	callDepth    int
+	// Jobs holds the asynchronous commands started in this shell. Copies
+	// get their own table so jobs started in a subshell stay there.
+	Jobs *jobs.Table
+	// CurrentJob is the background job commands in this scope belong to,
+	// nil in the foreground.
+	CurrentJob *jobs.Job
+	// traps maps signal names, and TrapExit, to the action set by trap.
+	traps       map[string]string
+	signalsSeen map[syscall.Signal]int
+	// Getopts records where getopts is within a group of options such
+	// as '-abc'.
+	Getopts GetoptsState
+	// Umask is applied to files created by redirections and commands.
+	Umask int
+	// Limits holds the resource limits set by ulimit for commands.
+	Limits map[int]syscall.Rlimit
+}
+
+// GetoptsState is the position of getopts in its arguments that is not
+// held in OPTIND. Char is the index of the next option in the argument
+// OPTIND refers to, 0 when getopts should start on a new argument. Index
+// is the OPTIND it applies to. Assigning or unsetting OPTIND clears the
+// state so that getopts starts over.
+type GetoptsState struct {
+	Index int
+	Char  int
 }
 
+// SetPwd changes the working directory of the Scope. Relative paths are
+// resolved against the current working directory.
+// The process working directory is never changed so that concurrent
+// subshells, and multiple shells in one process, each have their own.
+// External commands and relative paths are resolved using Pwd instead.
 func (s *Scope) SetPwd(dir string) error {
-	dir, err := filepath.Abs(filepath.Clean(dir))
+	dir = s.AbsPath(dir)
+	fi, err := os.Stat(dir)
 	if err != nil {
-		return err
+		if pathErr, ok := err.(*os.PathError); ok {
+			err = pathErr.Err
+		}
+		return &os.PathError{Op: "chdir", Path: dir, Err: err}
 	}
-	p := s.Pwd
-	if err := os.Chdir(dir); err != nil {
-		return err
+	if !fi.IsDir() {
+		return &os.PathError{Op: "chdir", Path: dir, Err: syscall.ENOTDIR}
 	}
-	s.OldPwd = p
+
+	s.OldPwd = s.Pwd
 	s.Set("OLDPWD", s.OldPwd)
 	s.Pwd = dir
 	s.Set("PWD", s.Pwd)
 	return nil
 }
 
+// AbsPath returns path made absolute using the working directory of the
+// Scope.
+func (s *Scope) AbsPath(path string) string {
+	if filepath.IsAbs(path) {
+		return filepath.Clean(path)
+	}
+	if s.Pwd == "" {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return path
+		}
+		return abs
+	}
+	return filepath.Join(s.Pwd, path)
+}
+
 func NewScope() *Scope {
 	s := Scope{}
 	s.scopes = []VarScope{}
 	s.scopes = append(s.scopes, VarScope{})
-	s.SetPwd(".")
+	s.params = [][]string{{}}
+	s.scriptName = "gosh"
+	wd, err := os.Getwd()
+	if err != nil {
+		wd = "/"
+	}
+	s.SetPwd(wd)
 	s.Functions = map[string]interface{}{}
+	s.options = map[ShellOption]bool{}
+	s.Jobs = jobs.NewTable()
+	s.traps = map[string]string{}
+	s.signalsSeen = map[syscall.Signal]int{}
+	s.Umask = processUmask()
+	s.Limits = map[int]syscall.Rlimit{}
 
 	return &s
 }
 
+// Copy returns a deep copy of the Scope for use by subshells. Changes made
+// to the copy are not visible in the original.
 func (s *Scope) Copy() *Scope {
 	newS := Scope{}
 	newS.currentScope = s.currentScope
+	newS.Pwd = s.Pwd
+	newS.OldPwd = s.OldPwd
+	newS.Functions = map[string]interface{}{}
+	for k, v := range s.Functions {
+		newS.Functions[k] = v
+	}
 	newS.scopes = []VarScope{}
 	for _, vs := range s.scopes {
 		x := VarScope{}
@@ -65,25 +157,71 @@ func (s *Scope) Copy() *Scope {
 		}
 		newS.scopes = append(newS.scopes, x)
 	}
+	// The parameter slices are never modified so they can be shared
+	newS.params = append([][]string{}, s.params...)
+	newS.scriptName = s.scriptName
+	newS.Getopts = s.Getopts
+	newS.Umask = s.Umask
+	newS.Limits = map[int]syscall.Rlimit{}
+	for k, v := range s.Limits {
+		newS.Limits[k] = v
+	}
+	newS.exitStatus = s.exitStatus
+	newS.conditions = s.conditions
+	newS.loopDepth = s.loopDepth
+	newS.callDepth = s.callDepth
+	newS.options = map[ShellOption]bool{}
+	for k, v := range s.options {
+		newS.options[k] = v
+	}
+	newS.Jobs = s.Jobs.Copy()
+	newS.CurrentJob = s.CurrentJob
+	// Subshells reset traps other than those ignoring a signal
+	newS.traps = map[string]string{}
+	newS.signalsSeen = map[syscall.Signal]int{}
+	for k, v := range s.signalsSeen {
+		newS.signalsSeen[k] = v
+	}
+	for k, v := range s.traps {
+		if v == "" {
+			newS.traps[k] = v
+			// Keep the signal ignored even if the original resets it
+			newS.updateSignals(k)
+		}
+	}
 	return &newS
 }
 
-// Push adds a VarScope to the scope stack.
+// Push adds a VarScope to the scope stack. The positional parameters are
+// unchanged.
 func (s *Scope) Push() {
 	s.scopes = append(s.scopes, VarScope{})
+	s.params = append(s.params, s.params[s.currentScope])
 	s.currentScope++
 }
 
+// PushFunction adds a VarScope with its own positional parameters for a
+// function call.
 func (s *Scope) PushFunction(args []string) {
 	s.Push()
 	s.SetPositionalArgs(args)
 }
 
+// SetPositionalArgs replaces the positional parameters, $1 onwards, of
+// the current VarScope.
 func (s *Scope) SetPositionalArgs(args []string) {
-	s.Set("#", strconv.Itoa(len(args)), LocalScope)
-	for i, a := range args {
-		s.Set(strconv.Itoa(i+1), a, LocalScope)
-	}
+	s.params[s.currentScope] = append([]string{}, args...)
+}
+
+// PositionalArgs returns the positional parameters, $1 onwards. The
+// slice must not be modified.
+func (s *Scope) PositionalArgs() []string {
+	return s.params[s.currentScope]
+}
+
+// SetScriptName sets $0.
+func (s *Scope) SetScriptName(name string) {
+	s.scriptName = name
 }
 
 // Pop removes the top VarScope from the scopes stack.
@@ -91,45 +229,128 @@ func (s *Scope) SetPositionalArgs(args []string) {
 func (s *Scope) Pop() {
 	if s.currentScope > 0 {
 		s.scopes = s.scopes[:s.currentScope]
+		s.params = s.params[:s.currentScope]
 		s.currentScope--
 	}
 }
 
 // Set walks down the scope stack checking for an existing variable to update.
 // If no variable of that name exists it is created in the root scope.
-func (s *Scope) Set(name, val string, opts ...ScopeOption) {
+// Attributes of an existing variable are kept. ErrReadOnly is returned if
+// the variable is readonly.
+func (s *Scope) Set(name, val string, opts ...ScopeOption) error {
+	if name == "OPTIND" {
+		s.Getopts = GetoptsState{}
+	}
 	if len(opts) > 0 {
 		// We only have Local option ATM forget checking them.
+		if s.Get(name).ReadOnly {
+			return ErrReadOnly
+		}
 		s.scopes[s.currentScope][name] = Variable{Val: val, Set: true}
-		return
+		return nil
+	}
+	i := s.find(name)
+	if i == -1 {
+		s.scopes[0][name] = Variable{Val: val, Set: true}
+		return nil
 	}
+	v := s.scopes[i][name]
+	if v.ReadOnly {
+		return ErrReadOnly
+	}
+	v.Val = val
+	v.Set = true
+	s.scopes[i][name] = v
+	return nil
+}
+
+// find returns the index of the innermost VarScope holding name or -1.
+func (s *Scope) find(name string) int {
 	for i := s.currentScope; i >= 0; i-- {
-		v, found := s.scopes[i][name]
-		if found {
-			if !v.ReadOnly {
-				v.Val = val
-				s.scopes[i][name] = v
-				return
-			}
-			panic(fmt.Sprintf("'%s' is read only"))
+		if _, found := s.scopes[i][name]; found {
+			return i
 		}
 	}
-	s.scopes[0][name] = Variable{Val: val, Set: true}
+	return -1
 }
 
 // SetString Sets a variable that is a single string in the form
 // 'A=1'
-func (s *Scope) SetString(input string, opts ...ScopeOption) {
+func (s *Scope) SetString(input string, opts ...ScopeOption) error {
 	parts := strings.SplitN(input, "=", 2)
 	if len(parts) != 2 {
 		panic("SetString given a string not containing an assignment")
 	}
-	s.Set(parts[0], parts[1], opts...)
+	return s.Set(parts[0], parts[1], opts...)
+}
+
+// Export marks the variable name to be passed to commands. It need not be
+// set yet, if it is assigned later the value is exported.
+func (s *Scope) Export(name string) {
+	i := s.find(name)
+	if i == -1 {
+		i = 0
+	}
+	v := s.scopes[i][name]
+	v.Exported = true
+	s.scopes[i][name] = v
+}
+
+// SetReadOnly prevents the variable name from being assigned or unset.
+func (s *Scope) SetReadOnly(name string) {
+	i := s.find(name)
+	if i == -1 {
+		i = 0
+	}
+	v := s.scopes[i][name]
+	v.ReadOnly = true
+	s.scopes[i][name] = v
+}
+
+// SetExitStatus records the exit status of the last command for '$?'.
+func (s *Scope) SetExitStatus(ex T.ExitStatus) {
+	s.exitStatus = ex
+}
+
+// ExitStatus returns the exit status of the last command.
+func (s *Scope) ExitStatus() T.ExitStatus {
+	return s.exitStatus
 }
 
 // Get walks down the scope stack and returns the variable if found.
 // If it is not set an empty variable is returned.
+// The special parameters are also returned, '$@' and '$*' as a single
+// string.
 func (s *Scope) Get(name string) Variable {
+	args := s.PositionalArgs()
+	switch name {
+	case "#":
+		return Variable{Val: strconv.Itoa(len(args)), Set: true}
+	case "@":
+		return Variable{Val: strings.Join(args, " "), Set: len(args) > 0}
+	case "*":
+		return Variable{Val: strings.Join(args, s.IFSSeparator()), Set: len(args) > 0}
+	case "0":
+		return Variable{Val: s.scriptName, Set: true}
+	case "$":
+		return Variable{Val: strconv.Itoa(os.Getpid()), Set: true}
+	case "?":
+		return Variable{Val: strconv.Itoa(int(s.exitStatus)), Set: true}
+	case "-":
+		return Variable{Val: s.OptionLetters(), Set: true}
+	case "!":
+		if j := s.Jobs.Last(); j != nil {
+			return Variable{Val: strconv.Itoa(j.Pid()), Set: true}
+		}
+		return Variable{}
+	}
+	if n, err := strconv.Atoi(name); err == nil && n > 0 {
+		if n > len(args) {
+			return Variable{}
+		}
+		return Variable{Val: args[n-1], Set: true}
+	}
 	for i := s.currentScope; i >= 0; i-- {
 		val, found := s.scopes[i][name]
 		if found {
@@ -139,35 +360,60 @@ func (s *Scope) Get(name string) Variable {
 	return Variable{}
 }
 
+// IFSSeparator returns the first character of IFS, used to join the
+// positional parameters for '"$*"'. It is a space if IFS is unset and
+// empty if IFS is empty.
+func (s *Scope) IFSSeparator() string {
+	v := s.Get("IFS")
+	if !v.Set {
+		return " "
+	}
+	for _, r := range v.Val {
+		return string(r)
+	}
+	return ""
+}
+
 // Unset sets the first variable encountered while walking down the
 // stack to nil values. We need to do this since unsetting local variables
 // still results in them masking set variables in outer scopes.
-func (s *Scope) Unset(name string) {
-	for i := s.currentScope; i >= 0; i-- {
-		v, found := s.scopes[i][name]
-		if found {
-			v.Set = false
-			v.Val = ""
-			s.scopes[i][name] = v
-			break
-		}
+// Its attributes are removed as well. ErrReadOnly is returned if the
+// variable is readonly.
+func (s *Scope) Unset(name string) error {
+	if name == "OPTIND" {
+		s.Getopts = GetoptsState{}
 	}
+	i := s.find(name)
+	if i == -1 {
+		return nil
+	}
+	if s.scopes[i][name].ReadOnly {
+		return ErrReadOnly
+	}
+	s.scopes[i][name] = Variable{}
+	return nil
 }
 
-func (s *Scope) Environ() []string {
-	// This cannot be the best way.
-	flatMap := map[string]string{}
+// Variables returns the variables visible from the current scope,
+// including unset ones that have an attribute.
+func (s *Scope) Variables() map[string]Variable {
+	flatMap := map[string]Variable{}
 	for i := 0; i <= s.currentScope; i++ {
 		for k, v := range s.scopes[i] {
-			if v.Set {
-				flatMap[k] = v.Val
-			}
+			flatMap[k] = v
 		}
 	}
+	return flatMap
+}
 
+// Environ returns the exported variables that are set in the form
+// 'A=1' for the environment of commands.
+func (s *Scope) Environ() []string {
 	environString := []string{}
-	for k, v := range flatMap {
-		environString = append(environString, fmt.Sprintf("%s=%s", k, v))
+	for k, v := range s.Variables() {
+		if v.Set && v.Exported {
+			environString = append(environString, fmt.Sprintf("%s=%s", k, v.Val))
+		}
 	}
 	return environString
 }
@@ -1,6 +1,11 @@
 package variables
 
-import "testing"
+import (
+	"os"
+	"sort"
+	"syscall"
+	"testing"
+)
 
 func TestNewScope(t *testing.T) {
 	s := NewScope()
@@ -77,3 +82,249 @@ func TestSetString(t *testing.T) {
 		t.Errorf("SetString did not split variable string correctl")
 	}
 }
+
+func TestScopeCopy(t *testing.T) {
+	s := NewScope()
+	s.Set("foo", "bar")
+	s.Functions["f"] = "body"
+
+	c := s.Copy()
+	c.Set("foo", "baz")
+	c.Functions["g"] = "body"
+	c.Pwd = "/"
+
+	if v := s.Get("foo"); v.Val != "bar" {
+		t.Errorf("Setting a variable in a copy changed the original")
+	}
+	if _, found := s.Functions["g"]; found {
+		t.Errorf("Defining a function in a copy changed the original")
+	}
+	if _, found := c.Functions["f"]; !found {
+		t.Errorf("Functions were not copied")
+	}
+	if s.Pwd == "/" {
+		t.Errorf("Changing Pwd in a copy changed the original")
+	}
+}
+
+func TestSetPwd(t *testing.T) {
+	wd, _ := os.Getwd()
+	s := NewScope()
+
+	if err := s.SetPwd("/"); err != nil {
+		t.Fatalf("SetPwd returned an error: %s", err.Error())
+	}
+	if s.Pwd != "/" || s.OldPwd != wd {
+		t.Errorf("Pwd should be '/' and OldPwd '%s' not '%s' and '%s'", wd, s.Pwd, s.OldPwd)
+	}
+	if newWd, _ := os.Getwd(); newWd != wd {
+		t.Errorf("SetPwd changed the process working directory")
+	}
+	if abs := s.AbsPath("tmp"); abs != "/tmp" {
+		t.Errorf("AbsPath should resolve against Pwd, got '%s'", abs)
+	}
+	if err := s.SetPwd("/nonexistent-directory"); err == nil {
+		t.Errorf("SetPwd to a missing directory should return an error")
+	}
+}
+
+func TestTrapsResetInCopy(t *testing.T) {
+	s := NewScope()
+	s.SetTrap("USR1", "echo hi")
+	s.SetTrap("USR2", "")
+	s.SetTrap(TrapExit, "echo bye")
+	defer func() {
+		for _, name := range s.TrapNames() {
+			s.ResetTrap(name)
+		}
+	}()
+
+	c := s.Copy()
+	defer c.ReleaseSignals()
+	if _, set := c.Trap("USR1"); set {
+		t.Errorf("Traps with an action should be reset in a copy")
+	}
+	if _, set := c.Trap(TrapExit); set {
+		t.Errorf("The EXIT trap should be reset in a copy")
+	}
+	if action, set := c.Trap("USR2"); !set || action != "" {
+		t.Errorf("Ignored signals should stay ignored in a copy")
+	}
+	if names := s.TrapNames(); len(names) != 3 || names[0] != TrapExit {
+		t.Errorf("Expected 3 sorted trap names, got %v", names)
+	}
+}
+
+func TestFunctionHidesLoops(t *testing.T) {
+	s := NewScope()
+	s.EnterLoop()
+	s.EnterLoop()
+
+	saved := s.EnterFunction()
+	if s.LoopDepth() != 0 || !s.InFunction() {
+		t.Errorf("Loops outside a function should not be visible inside it")
+	}
+	s.LeaveFunction(saved)
+
+	if s.LoopDepth() != 2 || s.InFunction() {
+		t.Errorf("Loop depth not restored after a function, got %d", s.LoopDepth())
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	s := NewScope()
+	s.Set("foo", "bar")
+	s.SetReadOnly("foo")
+
+	if err := s.Set("foo", "baz"); err != ErrReadOnly {
+		t.Errorf("Assigning a readonly variable should return ErrReadOnly, got %v", err)
+	}
+	if err := s.Unset("foo"); err != ErrReadOnly {
+		t.Errorf("Unsetting a readonly variable should return ErrReadOnly, got %v", err)
+	}
+	s.Push()
+	if err := s.Set("foo", "baz", LocalScope); err != ErrReadOnly {
+		t.Errorf("A local cannot hide a readonly variable, got %v", err)
+	}
+	s.Pop()
+	if v := s.Get("foo"); v.Val != "bar" {
+		t.Errorf("Readonly variable changed to '%s'", v.Val)
+	}
+}
+
+func TestEnvironExported(t *testing.T) {
+	s := NewScope()
+	s.Set("foo", "bar")
+	s.Set("baz", "qux")
+	s.Export("foo")
+	// Exporting before assignment exports the later value
+	s.Export("later")
+	s.Set("later", "value")
+
+	env := s.Environ()
+	sort.Strings(env)
+	if len(env) != 2 || env[0] != "foo=bar" || env[1] != "later=value" {
+		t.Errorf("Environ should only contain exported variables, got %v", env)
+	}
+
+	s.Unset("foo")
+	s.Set("foo", "new")
+	if env := s.Environ(); len(env) != 1 {
+		t.Errorf("Unset should remove the export attribute, got %v", env)
+	}
+}
+
+func TestImportEnviron(t *testing.T) {
+	s := NewScope()
+	s.ImportEnviron([]string{"FOO=bar=baz", "EMPTY=", "1BAD=x", "NOEQUALS", "=x", "="})
+
+	if v := s.Get("FOO"); v.Val != "bar=baz" || !v.Exported {
+		t.Errorf("FOO should be exported with the value 'bar=baz', got %#v", v)
+	}
+	if v := s.Get("EMPTY"); !v.Set || v.Val != "" {
+		t.Errorf("EMPTY should be set to an empty string, got %#v", v)
+	}
+	if v := s.Get("1BAD"); v.Set {
+		t.Errorf("Invalid names should not be imported")
+	}
+	if v := s.Get(""); v.Set {
+		t.Errorf("Entries without a name should not be imported")
+	}
+}
+
+func TestPositionalArgs(t *testing.T) {
+	s := NewScope()
+	s.SetPositionalArgs([]string{"a", "b", "c"})
+
+	s.PushFunction([]string{"x"})
+	if v := s.Get("3"); v.Set {
+		t.Errorf("A function should not see the parameters of its caller")
+	}
+	if v := s.Get("#"); v.Val != "1" {
+		t.Errorf("$# should be 1 in the function not '%s'", v.Val)
+	}
+	// Assignments before a command keep the parameters
+	s.Push()
+	if v := s.Get("1"); v.Val != "x" {
+		t.Errorf("Push should keep the parameters, got '%s'", v.Val)
+	}
+	s.Pop()
+	s.Pop()
+
+	if v := s.Get("*"); v.Val != "a b c" {
+		t.Errorf("Parameters not restored after the function, got '%s'", v.Val)
+	}
+	s.Set("IFS", ":")
+	if v := s.Get("*"); v.Val != "a:b:c" {
+		t.Errorf("$* should be joined with the first character of IFS, got '%s'", v.Val)
+	}
+}
+
+func TestWithProcessAttributes(t *testing.T) {
+	s := NewScope()
+	original := s.Umask
+	s.Umask = 077
+
+	c := s.Copy()
+	c.Umask = 0
+	if s.Umask != 077 {
+		t.Errorf("Changing Umask in a copy changed the original")
+	}
+
+	var applied int
+	s.WithProcessAttributes(func() error {
+		applied = syscall.Umask(077)
+		return nil
+	})
+	if applied != 077 {
+		t.Errorf("The umask of the Scope was not applied, got %04o", applied)
+	}
+	if mask := processUmask(); mask != original {
+		t.Errorf("The umask of the process was not restored, got %04o", mask)
+	}
+}
+
+func TestSetLimit(t *testing.T) {
+	var original syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &original); err != nil {
+		t.Fatal(err)
+	}
+	if original.Max < 64 {
+		t.Skip("The limit on open files is too low")
+	}
+
+	s := NewScope()
+	lim := syscall.Rlimit{Cur: 32, Max: 64}
+	if err := s.SetLimit(syscall.RLIMIT_NOFILE, lim); err != nil {
+		t.Fatalf("Setting a lower limit failed: %s", err)
+	}
+	if got, _ := s.Limit(syscall.RLIMIT_NOFILE); got != lim {
+		t.Errorf("Limit should return the limit of the Scope, got %v", got)
+	}
+	s.WithProcessAttributes(func() error { return nil })
+	var after syscall.Rlimit
+	syscall.Getrlimit(syscall.RLIMIT_NOFILE, &after)
+	if after != original {
+		t.Errorf("The limit of the process was changed to %v", after)
+	}
+
+	if err := s.SetLimit(syscall.RLIMIT_NOFILE, syscall.Rlimit{Cur: 64, Max: 32}); err != syscall.EINVAL {
+		t.Errorf("A soft limit above the hard limit should fail with EINVAL, got %v", err)
+	}
+}
+
+func TestIsGoodName(t *testing.T) {
+	for _, name := range []string{"a", "_", "FOO_1"} {
+		if !IsGoodName(name) {
+			t.Errorf("'%s' should be a good name", name)
+		}
+	}
+	for _, name := range []string{"", "1a", "a-b", "a=b"} {
+		if IsGoodName(name) {
+			t.Errorf("'%s' should not be a good name", name)
+		}
+	}
+	if IsAssignment("") || IsAssignment("=x") {
+		t.Errorf("An assignment needs a name")
+	}
+}